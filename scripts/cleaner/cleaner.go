@@ -0,0 +1,1229 @@
+// Package cleaner applies retention rules to the images in Amazon ECR
+// repositories and deletes the images that fall outside them.
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"scripts/logging"
+)
+
+// ECRAPI is the subset of the ECR client used by the Cleaner. It is
+// satisfied by *ecr.ECR and can be replaced by a fake in tests.
+type ECRAPI interface {
+	DescribeRepositoriesPagesWithContext(aws.Context, *ecr.DescribeRepositoriesInput, func(*ecr.DescribeRepositoriesOutput, bool) bool, ...request.Option) error
+	DescribeImagesPagesWithContext(aws.Context, *ecr.DescribeImagesInput, func(*ecr.DescribeImagesOutput, bool) bool, ...request.Option) error
+	BatchDeleteImageWithContext(aws.Context, *ecr.BatchDeleteImageInput, ...request.Option) (*ecr.BatchDeleteImageOutput, error)
+	GetLifecyclePolicyWithContext(aws.Context, *ecr.GetLifecyclePolicyInput, ...request.Option) (*ecr.GetLifecyclePolicyOutput, error)
+	ListTagsForResourceWithContext(aws.Context, *ecr.ListTagsForResourceInput, ...request.Option) (*ecr.ListTagsForResourceOutput, error)
+	DescribeImageScanFindingsWithContext(aws.Context, *ecr.DescribeImageScanFindingsInput, ...request.Option) (*ecr.DescribeImageScanFindingsOutput, error)
+	DeleteRepositoryWithContext(aws.Context, *ecr.DeleteRepositoryInput, ...request.Option) (*ecr.DeleteRepositoryOutput, error)
+	BatchGetImageWithContext(aws.Context, *ecr.BatchGetImageInput, ...request.Option) (*ecr.BatchGetImageOutput, error)
+}
+
+// maxBatchDeleteSize is the maximum number of image IDs BatchDeleteImage
+// accepts in a single call.
+const maxBatchDeleteSize = 100
+
+// Cleaner runs the cleanup against a single ECR client.
+type Cleaner struct {
+	svc ECRAPI
+	cfg Config
+
+	// Logger receives the progress log. It is shared by the workers and
+	// writes each entry as a single line.
+	Logger *logging.Logger
+	// Report, when set, receives one row per image considered.
+	Report *ReportWriter
+	// Region labels the summary and report rows.
+	Region string
+	// Prompter, when set outside dry-run mode, asks for approval of every
+	// deletion.
+	Prompter *Prompter
+	// Tagging, when set, is used to discover the repositories matching
+	// Config.ResourceTags instead of listing every repository.
+	Tagging TaggingAPI
+	// State, when set, records deleted images and finished repositories so
+	// that an interrupted run can resume where it stopped.
+	State *StateFile
+
+	// aborted is set after the first error when Config.FailFast is on.
+	aborted atomic.Bool
+	// deleteSlots bounds the BatchDeleteImage calls in flight across all
+	// repositories to Config.DeleteConcurrency.
+	deleteSlots chan struct{}
+
+	// resourceTags caches the AWS resource tags of each repository by ARN.
+	resourceTagsMu sync.Mutex
+	resourceTags   map[string]map[string]string
+}
+
+// New returns a Cleaner applying cfg to the repositories reachable through
+// svc. Logging is discarded until Logger is set.
+func New(svc ECRAPI, cfg Config) *Cleaner {
+	cfg.DeleteConcurrency = max(cfg.DeleteConcurrency, 1)
+	return &Cleaner{
+		svc:         svc,
+		cfg:         cfg,
+		Logger:      logging.Discard(),
+		deleteSlots: make(chan struct{}, cfg.DeleteConcurrency),
+	}
+}
+
+// Run lists the repositories, applies the repository filters and processes
+// each remaining repository across a pool of workers. When ctx is done the
+// run stops early and returns the partial summary.
+func (c *Cleaner) Run(ctx context.Context) (RunSummary, error) {
+	startTime := time.Now()
+	summary := RunSummary{
+		DryRun:       c.cfg.DryRun,
+		Repositories: []RepoSummary{},
+	}
+	if c.Region != "" {
+		summary.Regions = []string{c.Region}
+	}
+
+	// Step 4: List the repositories in scope
+	repos, protected, err := c.scope(ctx)
+	if err != nil {
+		return summary, err
+	}
+	summary.SkippedRepositories = append(summary.SkippedRepositories, protected...)
+
+	// Step 5: Process the repositories across a pool of workers, which
+	// send their results back over a channel. Results are stored by index
+	// so the summary keeps the repository order.
+	var processed, deletedSoFar atomic.Int64
+	stopProgress := c.reportProgress(len(repos), &processed, &deletedSoFar)
+	// process handles the repository at index i.
+	process := func(i int) RepoResult {
+		defer processed.Add(1)
+		result := c.processRepository(ctx, repos[i])
+		result.index = i
+		if result.Summary != nil {
+			deletedSoFar.Add(int64(result.Summary.Deleted))
+		}
+		return result
+	}
+
+	jobs := make(chan int)
+	resultsCh := make(chan RepoResult)
+	var wg sync.WaitGroup
+	for w := 0; w < c.cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if c.aborted.Load() || ctx.Err() != nil {
+					continue
+				}
+				resultsCh <- process(i)
+			}
+		}()
+	}
+	go func() {
+		for i := range repos {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(resultsCh)
+	}()
+	// Repositories never processed, after an abort, keep the zero result.
+	results := make([]RepoResult, len(repos))
+	for result := range resultsCh {
+		results[result.index] = result
+	}
+	stopProgress()
+
+	for i, result := range results {
+		repoName := aws.StringValue(repos[i].RepositoryName)
+		if result.Skipped {
+			summary.SkippedRepositories = append(summary.SkippedRepositories, repoName)
+		}
+		if result.Err != nil {
+			summary.Errors = append(summary.Errors, RepoError{
+				Region:     c.Region,
+				Repository: repoName,
+				Error:      result.Err.Error(),
+			})
+		}
+		if result.Summary == nil {
+			continue
+		}
+		summary.Repositories = append(summary.Repositories, *result.Summary)
+		summary.Totals.Add(result.Summary.ImageCounts)
+	}
+	summary.FailedRepositories = len(summary.Errors)
+	summary.Aborted = c.aborted.Load() || ctx.Err() != nil
+	summary.DurationSeconds = time.Since(startTime).Seconds()
+	return summary, nil
+}
+
+// RepoResult is the outcome of processing one repository, which the
+// workers send back to Run over a channel. Summary holds the scanned,
+// kept, deleted and failed counts, the bytes reclaimed and the per-image
+// errors; it is nil for a repository that was skipped or failed, in which
+// case Skipped or Err says which.
+type RepoResult struct {
+	Summary *RepoSummary
+	Skipped bool
+	Err     error
+
+	// index is the position of the repository in the run.
+	index int
+}
+
+// processRepository handles a single repository of the run: it skips the
+// repository when the state file, the incremental schedule or the
+// repository filters say so, cleans it up otherwise, and deletes it if
+// that leaves it empty. It is safe to call from multiple goroutines.
+func (c *Cleaner) processRepository(ctx context.Context, repo *ecr.Repository) RepoResult {
+	var result RepoResult
+	repoName := aws.StringValue(repo.RepositoryName)
+	if c.State.isCompleted(c.Region, repoName) {
+		c.logRepo(logging.LevelInfo, repoName, "⏭️ Skipping %s: finished by an earlier run (state file)", repoName)
+		result.Skipped = true
+		return result
+	}
+	if c.cfg.Incremental {
+		if until, ok := c.State.upToDate(c.Region, repoName, c.cfg.FullScanAfter); ok {
+			c.logRepo(logging.LevelInfo, repoName, "⏭️ Skipping %s: up to date until %s (incremental)", repoName, until.Format(time.RFC3339))
+			result.Skipped = true
+			return result
+		}
+	}
+	inScope, err := c.inScope(ctx, repo)
+	if err != nil {
+		result.Err = err
+		c.recordError()
+		return result
+	}
+	if !inScope {
+		result.Skipped = true
+		return result
+	}
+	repoSummary, err := c.cleanRepository(ctx, repoName)
+	if err != nil && ctx.Err() != nil {
+		c.logRepo(logging.LevelWarn, repoName, "Stopped processing %s: %v", repoName, ctx.Err())
+		return result
+	}
+	if aerr := awserr.Error(nil); errors.As(err, &aerr) && aerr.Code() == ecr.ErrCodeRepositoryNotFoundException {
+		c.logRepo(logging.LevelWarn, repoName, "⏭️ Skipping %s: repository not found", repoName)
+		result.Skipped = true
+		return result
+	}
+	if err != nil {
+		c.logRepo(logging.LevelWarn, repoName, "Failed to process %s: %v", repoName, err)
+		result.Err = err
+		c.recordError()
+		return result
+	}
+	if repoSummary.Retained == 0 && repoSummary.Failed == 0 && matchesAny(repoName, c.cfg.DeleteEmptyRepos) {
+		if err := c.deleteEmptyRepository(ctx, repoName); err != nil {
+			c.logRepo(logging.LevelError, repoName, "❌ Error deleting empty repository %s: %v", repoName, err)
+			result.Err = fmt.Errorf("failed to delete empty repository: %w", err)
+			c.recordError()
+		} else {
+			repoSummary.RepositoryDeleted = true
+		}
+	}
+	result.Summary = &repoSummary
+	if !c.cfg.DryRun && repoSummary.Failed == 0 {
+		if err := c.State.recordCompleted(c.Region, repoName); err != nil {
+			c.Logger.Errorf("Failed to write state file: %v", err)
+		}
+		if c.cfg.Incremental {
+			if err := c.State.recordScanned(c.Region, repoName, repoSummary.NextReview); err != nil {
+				c.Logger.Errorf("Failed to write state file: %v", err)
+			}
+		}
+	}
+	return result
+}
+
+// reportProgress logs a heartbeat every Config.ProgressInterval until the
+// returned function is called. The counters are updated by the workers.
+func (c *Cleaner) reportProgress(total int, processed, deleted *atomic.Int64) (stop func()) {
+	if c.cfg.ProgressInterval <= 0 || total == 0 {
+		return func() {}
+	}
+	verb := "deleted"
+	if c.cfg.DryRun {
+		verb = "would be deleted"
+	}
+	ticker := time.NewTicker(c.cfg.ProgressInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.Logger.Infof("⏳ Processed %d/%d repositories (%d images %s so far)",
+					processed.Load(), total, deleted.Load(), verb)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// Actions recorded on per-image log entries.
+const (
+	actionKeep    = "keep"
+	actionDelete  = "delete"
+	actionSuccess = "success"
+)
+
+// logRepo logs a message about a repository.
+func (c *Cleaner) logRepo(level logging.Level, repoName, format string, args ...any) {
+	c.Logger.Log(logging.Entry{
+		Level:      level,
+		Repository: repoName,
+		Message:    fmt.Sprintf(format, args...),
+	})
+}
+
+// logImage logs a decision about, or the outcome for, a single image.
+// In quiet mode only warnings and errors are logged.
+func (c *Cleaner) logImage(level logging.Level, action, repoName, digest, format string, args ...any) {
+	if c.cfg.Quiet && level < logging.LevelWarn {
+		return
+	}
+	c.Logger.Log(logging.Entry{
+		Level:      level,
+		Repository: repoName,
+		Digest:     digest,
+		Action:     action,
+		Message:    fmt.Sprintf(format, args...),
+	})
+}
+
+// recordError notes that an error occurred, aborting the rest of the run
+// when Config.FailFast is set.
+func (c *Cleaner) recordError() {
+	if c.cfg.FailFast && c.aborted.CompareAndSwap(false, true) {
+		c.Logger.Errorf("❌ Aborting the run after the first error (fail-fast)")
+	}
+}
+
+// FilterRepositories keeps the repositories matching the include patterns
+// (all of them when there are none) and not matching the exclude patterns.
+func FilterRepositories(repos []*ecr.Repository, include, exclude []string) []*ecr.Repository {
+	var matched []*ecr.Repository
+	for _, repo := range repos {
+		name := aws.StringValue(repo.RepositoryName)
+		if len(include) > 0 && !matchesAny(name, include) {
+			continue
+		}
+		if matchesAny(name, exclude) {
+			continue
+		}
+		matched = append(matched, repo)
+	}
+	return matched
+}
+
+// listRepositories returns every repository in the region, following
+// NextToken until all pages have been read.
+func (c *Cleaner) listRepositories(ctx context.Context) ([]*ecr.Repository, error) {
+	var repos []*ecr.Repository
+	err := c.svc.DescribeRepositoriesPagesWithContext(ctx, &ecr.DescribeRepositoriesInput{},
+		func(page *ecr.DescribeRepositoriesOutput, lastPage bool) bool {
+			repos = append(repos, page.Repositories...)
+			return true
+		})
+	return repos, err
+}
+
+// hasLifecyclePolicy reports whether the repository has an ECR lifecycle
+// policy. A missing policy is not an error.
+func (c *Cleaner) hasLifecyclePolicy(ctx context.Context, repoName string) (bool, error) {
+	_, err := c.svc.GetLifecyclePolicyWithContext(ctx, &ecr.GetLifecyclePolicyInput{
+		RepositoryName: aws.String(repoName),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeLifecyclePolicyNotFoundException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deleteEmptyRepository deletes a repository left without images. The
+// deletion is not forced, so ECR refuses it if an image was pushed in the
+// meantime. In dry-run mode it is only logged.
+func (c *Cleaner) deleteEmptyRepository(ctx context.Context, repoName string) error {
+	if c.cfg.DryRun {
+		c.logRepo(logging.LevelInfo, repoName, "🗑️ Empty repository would be deleted: %s", repoName)
+		return nil
+	}
+	_, err := c.svc.DeleteRepositoryWithContext(ctx, &ecr.DeleteRepositoryInput{
+		RepositoryName: aws.String(repoName),
+		Force:          aws.Bool(false),
+	})
+	if err != nil {
+		return err
+	}
+	c.logRepo(logging.LevelInfo, repoName, "🗑️ Empty repository deleted: %s", repoName)
+	return nil
+}
+
+// matchesResourceTags reports whether the repository carries every tag in
+// Config.ResourceTags. Tag lookups are cached, and a repository without
+// tags does not match.
+func (c *Cleaner) matchesResourceTags(ctx context.Context, repo *ecr.Repository) (bool, error) {
+	arn := aws.StringValue(repo.RepositoryArn)
+	c.resourceTagsMu.Lock()
+	tags, ok := c.resourceTags[arn]
+	c.resourceTagsMu.Unlock()
+	if !ok {
+		out, err := c.svc.ListTagsForResourceWithContext(ctx, &ecr.ListTagsForResourceInput{
+			ResourceArn: repo.RepositoryArn,
+		})
+		if err != nil {
+			return false, err
+		}
+		tags = make(map[string]string)
+		for _, tag := range out.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		c.resourceTagsMu.Lock()
+		if c.resourceTags == nil {
+			c.resourceTags = make(map[string]map[string]string)
+		}
+		c.resourceTags[arn] = tags
+		c.resourceTagsMu.Unlock()
+	}
+
+	for key, value := range c.cfg.ResourceTags {
+		if got, ok := tags[key]; !ok || got != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// listImages returns every image in the repository, following NextToken
+// until all pages have been read.
+func (c *Cleaner) listImages(ctx context.Context, repoName string) ([]*ecr.ImageDetail, error) {
+	var images []*ecr.ImageDetail
+	err := c.svc.DescribeImagesPagesWithContext(ctx, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(repoName),
+	}, func(page *ecr.DescribeImagesOutput, lastPage bool) bool {
+		images = append(images, page.ImageDetails...)
+		return true
+	})
+	return images, err
+}
+
+// deleteResult is the outcome of deleting a set of images.
+type deleteResult struct {
+	deleted []*ecr.ImageIdentifier
+	// deferred counts images left in place because a manifest list still
+	// references them; they can be deleted once the list is gone.
+	deferred int
+	failures []ImageFailure
+}
+
+// add accumulates other into r.
+func (r *deleteResult) add(other deleteResult) {
+	r.deleted = append(r.deleted, other.deleted...)
+	r.deferred += other.deferred
+	r.failures = append(r.failures, other.failures...)
+}
+
+// deleteImages deletes the given images in batches of maxBatchDeleteSize,
+// reporting each failed digest individually. Up to
+// Config.DeleteConcurrency batches are deleted at once, and no more than
+// that many across all the repositories of the run.
+func (c *Cleaner) deleteImages(ctx context.Context, repoName string, imageIds []*ecr.ImageIdentifier) deleteResult {
+	batches := deleteBatches(imageIds)
+
+	// Results are stored by batch so that they aggregate in order
+	results := make([]deleteResult, len(batches))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(c.cfg.DeleteConcurrency, len(batches)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = c.deleteBatchLimited(ctx, repoName, batches[i])
+			}
+		}()
+	}
+	for i := range batches {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var result deleteResult
+	for _, batchResult := range results {
+		result.add(batchResult)
+	}
+	return result
+}
+
+// deleteBatches splits the identifiers into BatchDeleteImage batches of
+// up to maxBatchDeleteSize, keeping their order.
+func deleteBatches(imageIds []*ecr.ImageIdentifier) [][]*ecr.ImageIdentifier {
+	var batches [][]*ecr.ImageIdentifier
+	for start := 0; start < len(imageIds); start += maxBatchDeleteSize {
+		batches = append(batches, imageIds[start:min(start+maxBatchDeleteSize, len(imageIds))])
+	}
+	return batches
+}
+
+// deleteBatchLimited deletes one batch once a slot of the run-wide delete
+// semaphore is free, logging the outcome.
+func (c *Cleaner) deleteBatchLimited(ctx context.Context, repoName string, batch []*ecr.ImageIdentifier) deleteResult {
+	select {
+	case c.deleteSlots <- struct{}{}:
+		defer func() { <-c.deleteSlots }()
+	case <-ctx.Done():
+	}
+	if c.aborted.Load() || ctx.Err() != nil {
+		c.logRepo(logging.LevelWarn, repoName, "Skipping deletion of %d images in %s: the run was stopped", len(batch), repoName)
+		return deleteResult{failures: newImageFailures(batch, "", "run stopped")}
+	}
+
+	result, err := c.deleteBatch(ctx, repoName, batch)
+	if err != nil {
+		c.logRepo(logging.LevelError, repoName, "❌ Error deleting batch of %d images from %s: %v", len(batch), repoName, err)
+		c.recordError()
+		return result
+	}
+	if len(result.failures) > 0 {
+		c.recordError()
+	}
+	c.logRepo(logging.LevelInfo, repoName, "Batch delete in %s: %d deleted, %d deferred, %d failed",
+		repoName, len(result.deleted), result.deferred, len(result.failures))
+	return result
+}
+
+// retryableFailures are the BatchDeleteImage failure codes worth retrying.
+// Every other code, such as InvalidImageDigest, is permanent.
+var retryableFailures = map[string]bool{
+	ecr.ImageFailureCodeKmsError:                true,
+	ecr.ImageFailureCodeUpstreamTooManyRequests: true,
+	ecr.ImageFailureCodeUpstreamUnavailable:     true,
+}
+
+// retryDelay is the wait before the first retry of failed images; it grows
+// linearly with each attempt.
+const retryDelay = time.Second
+
+// deleteBatch deletes a single batch. Images that fail with a transient
+// error are retried as a smaller batch up to Config.MaxRetries times. An
+// error is returned, with every pending image failed, if a call fails as a
+// whole.
+func (c *Cleaner) deleteBatch(ctx context.Context, repoName string, batch []*ecr.ImageIdentifier) (deleteResult, error) {
+	var result deleteResult
+	pending := batch
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			c.logRepo(logging.LevelInfo, repoName, "Retrying %d failed images in %s (attempt %d of %d)",
+				len(pending), repoName, attempt, c.cfg.MaxRetries)
+			select {
+			case <-time.After(time.Duration(attempt) * retryDelay):
+			case <-ctx.Done():
+				result.failures = append(result.failures, newImageFailures(pending, "", "run stopped")...)
+				return result, ctx.Err()
+			}
+		}
+
+		output, err := c.svc.BatchDeleteImageWithContext(ctx, &ecr.BatchDeleteImageInput{
+			RepositoryName: aws.String(repoName),
+			ImageIds:       pending,
+		})
+		if err != nil {
+			result.failures = append(result.failures, newImageFailures(pending, "", err.Error())...)
+			return result, err
+		}
+
+		for _, id := range output.ImageIds {
+			digest := aws.StringValue(id.ImageDigest)
+			if id.ImageTag != nil {
+				c.logImage(logging.LevelInfo, actionSuccess, repoName, digest, "✅ Tag deleted: %s", *id.ImageTag)
+				continue
+			}
+			c.logImage(logging.LevelInfo, actionSuccess, repoName, digest, "✅ Image deleted: %s", digest)
+		}
+		result.deleted = append(result.deleted, output.ImageIds...)
+		if err := c.State.recordDeleted(c.Region, repoName, output.ImageIds); err != nil {
+			c.Logger.Errorf("Failed to write state file: %v", err)
+		}
+
+		var retry []*ecr.ImageIdentifier
+		for _, failure := range output.Failures {
+			digest := aws.StringValue(failure.ImageId.ImageDigest)
+			if digest == "" {
+				digest = aws.StringValue(failure.ImageId.ImageTag)
+			}
+			code, reason := aws.StringValue(failure.FailureCode), aws.StringValue(failure.FailureReason)
+			// Another run may have deleted the image first; that is not a
+			// failure of this run.
+			if code == ecr.ImageFailureCodeImageNotFound {
+				c.logImage(logging.LevelInfo, "", repoName, digest, "Image already deleted: %s", digest)
+				continue
+			}
+			// A child of a manifest list that is kept cannot be deleted
+			// before its parent.
+			if code == ecr.ImageFailureCodeImageReferencedByManifestList {
+				c.logImage(logging.LevelInfo, "", repoName, digest, "⏸️ Deferring deletion of %s: still referenced by a manifest list", digest)
+				result.deferred++
+				continue
+			}
+			if retryableFailures[code] && attempt < c.cfg.MaxRetries {
+				c.logImage(logging.LevelWarn, "", repoName, digest, "Transient error deleting image %s: %s: %s", digest, code, reason)
+				retry = append(retry, failure.ImageId)
+				continue
+			}
+			c.logImage(logging.LevelError, "", repoName, digest, "❌ Error deleting image %s: %s: %s", digest, code, reason)
+			result.failures = append(result.failures, newImageFailures([]*ecr.ImageIdentifier{failure.ImageId}, code, reason)...)
+		}
+		pending = retry
+	}
+	return result, nil
+}
+
+// newerFirst orders images newest first. Images pushed at the same time are
+// ordered by digest so the retained set is the same on every run.
+func newerFirst(a time.Time, aDigest string, b time.Time, bDigest string) bool {
+	if !a.Equal(b) {
+		return a.After(b)
+	}
+	return aDigest < bDigest
+}
+
+// taggedImage is a tagged image that matched one of the keep patterns.
+type taggedImage struct {
+	digest     string
+	tags       []*string
+	pushedTime time.Time
+	// version is the highest semantic version among the tags, used when
+	// keeping by version.
+	version string
+}
+
+// cleanRepository applies the retention rules to a single repository,
+// deleting the images that fall outside them unless running in dry-run
+// mode. It is safe to call from multiple goroutines.
+func (c *Cleaner) cleanRepository(ctx context.Context, repoName string) (RepoSummary, error) {
+	c.logRepo(logging.LevelInfo, repoName, "📦 Processing Repository: %s", repoName)
+
+	// Step 6: Get all images in the repository
+	repoSummary := RepoSummary{Region: c.Region, Repository: repoName}
+	imageDetails, err := c.listImages(ctx, repoName)
+	if err != nil {
+		return repoSummary, fmt.Errorf("failed to describe images: %w", err)
+	}
+
+	// Images deleted by an earlier, interrupted run may still be listed
+	// for a while
+	if c.State != nil {
+		var remaining []*ecr.ImageDetail
+		for _, image := range imageDetails {
+			if !c.State.isDeleted(c.Region, repoName, aws.StringValue(image.ImageDigest)) {
+				remaining = append(remaining, image)
+			}
+		}
+		if skipped := len(imageDetails) - len(remaining); skipped > 0 {
+			c.logRepo(logging.LevelInfo, repoName, "Skipping %d images in %s deleted by an earlier run (state file)", skipped, repoName)
+		}
+		imageDetails = remaining
+	}
+
+	repoSummary.Scanned = len(imageDetails)
+	if len(imageDetails) == 0 {
+		level := logging.LevelInfo
+		if c.cfg.QuietEmptyRepos {
+			level = logging.LevelDebug
+		}
+		c.logRepo(level, repoName, "No images found in repository %s", repoName)
+		return repoSummary, nil
+	}
+
+	// Step 7: Group images by prefix. An image carrying several matching
+	// tags is added to each prefix bucket only once. Excluded images are
+	// kept anyway, so they do not use up the keep counts.
+	prefixMatchMap := make(map[string][]taggedImage)
+	matchedDigests := make(map[string]bool)
+	patternsOf := make(map[string][]string)
+
+	for _, image := range imageDetails {
+		if image.ImagePushedAt == nil || len(image.ImageTags) == 0 || c.cfg.excluded(image) {
+			continue
+		}
+		added := make(map[string]bool)
+		for _, tag := range image.ImageTags {
+			for _, matcher := range c.cfg.Matchers {
+				if matcher.Matches(*tag) {
+					if added[matcher.Pattern] {
+						break
+					}
+					added[matcher.Pattern] = true
+					matchedDigests[*image.ImageDigest] = true
+					patternsOf[*image.ImageDigest] = append(patternsOf[*image.ImageDigest], matcher.Pattern)
+					prefixMatchMap[matcher.Pattern] = append(prefixMatchMap[matcher.Pattern], taggedImage{
+						digest:     *image.ImageDigest,
+						tags:       image.ImageTags,
+						pushedTime: *image.ImagePushedAt,
+						version:    highestVersion(image.ImageTags),
+					})
+					break
+				}
+			}
+		}
+	}
+
+	// The default rule of a policy file covers the tagged images that
+	// matched no prefix
+	var unmatchedImages []taggedImage
+	if c.cfg.DefaultRule != nil {
+		for _, image := range imageDetails {
+			if image.ImagePushedAt != nil && len(image.ImageTags) > 0 && !matchedDigests[*image.ImageDigest] {
+				unmatchedImages = append(unmatchedImages, taggedImage{
+					digest:     *image.ImageDigest,
+					tags:       image.ImageTags,
+					pushedTime: *image.ImagePushedAt,
+					version:    highestVersion(image.ImageTags),
+				})
+			}
+		}
+	}
+
+	// Step 8: Build a set of digests to retain (top N per prefix, by push
+	// time or by version). Prefixes are taken in order, and an image
+	// already retained by an earlier prefix does not use up the count of
+	// a later one, so each prefix keeps N images of its own.
+	retainedDigests := make(map[string]bool)
+	retainTop := func(images []taggedImage, keep int) {
+		sort.Slice(images, func(i, j int) bool {
+			if c.cfg.KeepBy == KeepBySemver {
+				return higherVersionFirst(images[i], images[j])
+			}
+			return newerFirst(images[i].pushedTime, images[i].digest, images[j].pushedTime, images[j].digest)
+		})
+
+		// By version, keep counts versions, each with all its images. A
+		// version every image of which is already retained is skipped
+		if c.cfg.KeepBy == KeepByVersion {
+			kept := 0
+			for _, group := range c.cfg.versionGroups(images) {
+				if kept == keep {
+					break
+				}
+				added := false
+				for _, image := range group {
+					if !retainedDigests[image.digest] {
+						retainedDigests[image.digest] = true
+						added = true
+					}
+				}
+				if added {
+					kept++
+				}
+			}
+			return
+		}
+
+		kept := 0
+		for i := 0; i < len(images) && kept < keep; i++ {
+			if !retainedDigests[images[i].digest] {
+				retainedDigests[images[i].digest] = true
+				kept++
+			}
+		}
+	}
+	for _, matcher := range c.cfg.Matchers {
+		if images, ok := prefixMatchMap[matcher.Pattern]; ok {
+			retainTop(images, c.cfg.keepFor(repoName, matcher.Pattern))
+			delete(prefixMatchMap, matcher.Pattern)
+		}
+	}
+	if c.cfg.DefaultRule != nil {
+		retainTop(unmatchedImages, c.cfg.keepUnmatched(repoName))
+	}
+
+	// Protected tags take precedence over every deletion rule
+	protectedDigests := make(map[string]bool)
+	for _, image := range imageDetails {
+		for _, tag := range image.ImageTags {
+			if c.cfg.ProtectTags[aws.StringValue(tag)] {
+				protectedDigests[aws.StringValue(image.ImageDigest)] = true
+				break
+			}
+		}
+	}
+
+	// Images tagged keep-until-YYYY-MM-DD are kept until that date passes
+	keptUntil := make(map[string]time.Time)
+	for _, image := range imageDetails {
+		digest := aws.StringValue(image.ImageDigest)
+		for _, tag := range aws.StringValueSlice(image.ImageTags) {
+			until, ok, err := parseKeepUntil(tag)
+			if err != nil {
+				c.logImage(logging.LevelWarn, "", repoName, digest, "Ignoring malformed tag %q on %s: %v", tag, digest, err)
+				continue
+			}
+			if ok && time.Now().Before(until) && until.After(keptUntil[digest]) {
+				keptUntil[digest] = until
+			}
+		}
+	}
+
+	// Referrers, such as signatures and SBOMs, are decided after the
+	// images they refer to, and follow them
+	var subjects map[string]string
+	if c.cfg.FollowReferrers {
+		subjects, err = c.referrerSubjects(ctx, repoName, imageDetails)
+		if err != nil {
+			return repoSummary, fmt.Errorf("failed to get manifests: %w", err)
+		}
+	}
+
+	// Top up the retained set so at least MinKeep of the newest images
+	// survive, whatever their age. Referrers follow their subjects, so
+	// they neither count towards MinKeep nor take its places.
+	minKept := make(map[string]bool)
+	survives := func(image *ecr.ImageDetail) bool {
+		digest := aws.StringValue(image.ImageDigest)
+		switch {
+		case image.ImagePushedAt == nil, retainedDigests[digest], protectedDigests[digest], c.cfg.ProtectDigests[digest], c.cfg.excluded(image), c.cfg.widelyTagged(image),
+			!c.cfg.targetsMediaType(image):
+			return true
+		case c.cfg.inGracePeriod(image), !c.cfg.largeEnough(image):
+			return true
+		case len(image.ImageTags) == 0:
+			return !c.cfg.DeleteUntagged || !c.cfg.untaggedExpired(image)
+		}
+		_, kept := keptUntil[digest]
+		unmatched := !c.cfg.includesUnmatched() && !matchedDigests[digest]
+		return kept || unmatched || c.cfg.alwaysKept(patternsOf[digest]) || !c.cfg.expiredUnder(image, patternsOf[digest])
+	}
+	surviving := 0
+	var pushed []*ecr.ImageDetail
+	for _, image := range imageDetails {
+		if _, ok := subjects[aws.StringValue(image.ImageDigest)]; ok {
+			continue
+		}
+		if survives(image) {
+			surviving++
+		} else {
+			pushed = append(pushed, image)
+		}
+	}
+	if surviving < c.cfg.MinKeep {
+		sort.Slice(pushed, func(i, j int) bool {
+			return newerFirst(*pushed[i].ImagePushedAt, aws.StringValue(pushed[i].ImageDigest),
+				*pushed[j].ImagePushedAt, aws.StringValue(pushed[j].ImageDigest))
+		})
+		for i := 0; i < len(pushed) && surviving < c.cfg.MinKeep; i++ {
+			minKept[aws.StringValue(pushed[i].ImageDigest)] = true
+			surviving++
+		}
+	}
+
+	// Step 9: Process each image. In dry-run mode every decision is also
+	// collected into the plan.
+	var plan []Decision
+	decide := func(image *ecr.ImageDetail, decision, reason string) {
+		c.Report.record(c.Region, repoName, image, decision, reason)
+		if c.cfg.DryRun && !c.cfg.ReportOnly {
+			plan = append(plan, c.cfg.newDecision(image, decision, reason))
+		}
+	}
+	// reviewAt notes when an image kept for now may become deletable, for
+	// incremental runs. Candidates that were kept anyway are reviewed by
+	// the next run.
+	reviewAt := func(t time.Time) {
+		if repoSummary.NextReview == nil || t.Before(*repoSummary.NextReview) {
+			repoSummary.NextReview = &t
+		}
+	}
+	confirm := &confirmer{repoName: repoName}
+	if !c.cfg.DryRun {
+		confirm.prompter = c.Prompter
+	}
+	var untaggedToDelete, oldToDelete, tagsToDelete []*ecr.ImageIdentifier
+	var referrers []*ecr.ImageDetail
+	imageSizes := make(map[string]int64)
+	manifestLists := make(map[string]bool)
+	for _, image := range imageDetails {
+		imageSizes[aws.StringValue(image.ImageDigest)] = aws.Int64Value(image.ImageSizeInBytes)
+		if isManifestList(image) {
+			manifestLists[aws.StringValue(image.ImageDigest)] = true
+		}
+		// Protected digests are kept before any other rule is applied
+		if digest := aws.StringValue(image.ImageDigest); c.cfg.ProtectDigests[digest] {
+			c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "🔒 Image retained (protected digest): %s | Tags: %v", digest, aws.StringValueSlice(image.ImageTags))
+			decide(image, decisionKeep, "protected digest")
+			continue
+		}
+		if _, ok := subjects[aws.StringValue(image.ImageDigest)]; ok {
+			referrers = append(referrers, image)
+			continue
+		}
+		if image.ImagePushedAt == nil {
+			decide(image, decisionKeep, "no push time")
+			continue
+		}
+		imageAge := int(time.Since(c.cfg.agedFrom(image)).Hours() / 24)
+		digest := aws.StringValue(image.ImageDigest)
+		tags := aws.StringValueSlice(image.ImageTags)
+
+		if !c.cfg.targetsMediaType(image) {
+			c.logImage(logging.LevelInfo, "", repoName, digest, "⏭️ Skipping %s: media type %q is not targeted",
+				digest, mediaType(image))
+			decide(image, decisionKeep, "media type not targeted")
+			continue
+		}
+
+		if minKept[digest] {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (minimum keep floor): %s | Tags: %v", digest, tags)
+			decide(image, decisionKeep, "minimum keep floor")
+			continue
+		}
+
+		// Nothing pushed within the grace period is deleted
+		if c.cfg.inGracePeriod(image) {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (grace period): %s | Tags: %v", digest, tags)
+			decide(image, decisionKeep, "within grace period")
+			reviewAt(image.ImagePushedAt.Add(c.cfg.gracePeriodFor(image)))
+			continue
+		}
+
+		// Untagged images
+		if len(image.ImageTags) == 0 {
+			switch {
+			case !c.cfg.DeleteUntagged:
+				c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Untagged image retained (-delete-untagged=false): %s", digest)
+				decide(image, decisionKeep, "untagged deletion disabled")
+			case !c.cfg.untaggedExpired(image):
+				decide(image, decisionKeep, c.cfg.keepReason(image, "within untagged retention"))
+				reviewAt(c.cfg.untaggedExpiry(image))
+			case !c.cfg.largeEnough(image):
+				decide(image, decisionKeep, "below minimum size")
+			case !c.cfg.withinCap(c.Region, repoName, digest):
+				c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "✅ Untagged image retained (over the run's delete cap): %s", digest)
+				decide(image, decisionKeep, "over delete cap")
+				reviewAt(time.Now())
+			case c.quarantined(ctx, repoName, image):
+				decide(image, decisionKeep, "scan findings")
+				reviewAt(time.Now())
+			default:
+				confirm.request(digest+" (untagged)", digest, func(approved bool) {
+					if !approved {
+						c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "✅ Untagged image retained (not approved): %s", digest)
+						decide(image, decisionKeep, "not approved")
+						reviewAt(time.Now())
+						return
+					}
+					c.logImage(logging.LevelInfo, actionDelete, repoName, digest, "🗑️ Untagged image to delete: %s | Age: %d days | Size: %s",
+						digest, imageAge, FormatBytes(aws.Int64Value(image.ImageSizeInBytes)))
+					decide(image, c.cfg.deleteDecision(), c.cfg.deleteReason(image, "untagged"))
+					untaggedToDelete = append(untaggedToDelete, &ecr.ImageIdentifier{ImageDigest: image.ImageDigest})
+				})
+			}
+			continue
+		}
+
+		// Retained?
+		if protectedDigests[*image.ImageDigest] {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (protected tag): %s | Tags: %v", digest, tags)
+			decide(image, decisionKeep, "protected tag")
+
+			// In delete-by-tag mode the unprotected tags of an expired
+			// image are removed, leaving the protected ones in place. They
+			// pass the same checks as a deletion of the image.
+			_, kept := keptUntil[digest]
+			if c.cfg.DeleteByTag && !kept && !retainedDigests[digest] && !c.cfg.alwaysKept(patternsOf[digest]) &&
+				c.cfg.expiredUnder(image, patternsOf[digest]) && c.cfg.largeEnough(image) &&
+				!c.quarantined(ctx, repoName, image) {
+				if !c.cfg.withinCap(c.Region, repoName, digest) {
+					c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "✅ Tags retained (over the run's delete cap): %s | Tags: %v", digest, tags)
+					reviewAt(time.Now())
+					continue
+				}
+				for _, tag := range image.ImageTags {
+					if c.cfg.ProtectTags[*tag] {
+						continue
+					}
+					confirm.request(fmt.Sprintf("tag %s from %s", *tag, digest), "", func(approved bool) {
+						if approved {
+							c.logImage(logging.LevelInfo, actionDelete, repoName, digest, "🏷️ Tag to delete: %s (keeping protected tags on %s)", *tag, digest)
+							tagsToDelete = append(tagsToDelete, &ecr.ImageIdentifier{ImageTag: tag})
+						}
+					})
+				}
+			}
+			continue
+		}
+		if c.cfg.excluded(image) {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (excluded prefix): %s | Tags: %v", digest, tags)
+			decide(image, decisionKeep, "excluded prefix")
+			continue
+		}
+		// Deleting a digest removes all of its tags at once
+		if c.cfg.widelyTagged(image) {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (%d tags, over -tag-count-threshold): %s | Tags: %v",
+				len(tags), digest, tags)
+			decide(image, decisionKeep, "tag count threshold")
+			continue
+		}
+		if until, ok := keptUntil[digest]; ok {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (keep-until %s): %s | Tags: %v",
+				until.AddDate(0, 0, -1).Format(keepUntilLayout), digest, tags)
+			decide(image, decisionKeep, "keep-until tag")
+			reviewAt(until)
+			continue
+		}
+		if c.cfg.alwaysKept(patternsOf[digest]) {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (always-keep rule): %s | Tags: %v", digest, tags)
+			decide(image, decisionKeep, "always-keep rule")
+			continue
+		}
+		if retainedDigests[*image.ImageDigest] {
+			reason := "latest tag-match"
+			switch c.cfg.KeepBy {
+			case KeepBySemver:
+				reason = "highest version tag-match"
+			case KeepByVersion:
+				reason = "latest version group"
+			}
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (%s): %s | Tags: %v", reason, digest, tags)
+			decide(image, decisionKeep, reason)
+			continue
+		}
+
+		if !c.cfg.includesUnmatched() && !matchedDigests[digest] {
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (no tag matches a prefix): %s | Tags: %v", digest, tags)
+			decide(image, decisionKeep, "no matching prefix")
+			continue
+		}
+
+		// Delete if older than retention (and the -before cutoff, if set)
+		if c.cfg.expiredUnder(image, patternsOf[digest]) {
+			if !c.cfg.largeEnough(image) {
+				decide(image, decisionKeep, "below minimum size")
+				continue
+			}
+			if c.quarantined(ctx, repoName, image) {
+				decide(image, decisionKeep, "scan findings")
+				reviewAt(time.Now())
+				continue
+			}
+			if !c.cfg.withinCap(c.Region, repoName, digest) {
+				c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "✅ Image retained (over the run's delete cap): %s | Tags: %v", digest, tags)
+				decide(image, decisionKeep, "over delete cap")
+				reviewAt(time.Now())
+				continue
+			}
+			confirm.request(fmt.Sprintf("%s (tags: %s)", digest, strings.Join(tags, ", ")), digest, func(approved bool) {
+				if !approved {
+					c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "✅ Image retained (not approved): %s | Tags: %v", digest, tags)
+					decide(image, decisionKeep, "not approved")
+					reviewAt(time.Now())
+					return
+				}
+				c.logImage(logging.LevelInfo, actionDelete, repoName, digest, "🗑️ Old image to delete: %s | Age: %d days | Last pull: %s | Size: %s | Tags: %v",
+					digest, imageAge, formatAge(image.LastRecordedPullTime), FormatBytes(aws.Int64Value(image.ImageSizeInBytes)), tags)
+				decide(image, c.cfg.deleteDecision(), c.cfg.deleteReason(image, "older than retention"))
+
+				oldToDelete = append(oldToDelete, &ecr.ImageIdentifier{ImageDigest: image.ImageDigest})
+			})
+			continue
+		}
+		decide(image, decisionKeep, c.cfg.keepReason(image, "within retention"))
+		reviewAt(c.cfg.expiryUnder(image, patternsOf[digest]))
+	}
+
+	// A referrer is deleted with its subject and kept with it, and as an
+	// untagged image it is also kept by the rules that keep those. deleting
+	// returns the digests slated for deletion, counting those still awaiting
+	// approval.
+	deleting := func() map[string]bool {
+		deleting := make(map[string]bool)
+		for _, id := range append(untaggedToDelete, oldToDelete...) {
+			deleting[aws.StringValue(id.ImageDigest)] = true
+		}
+		for _, digest := range confirm.pending() {
+			deleting[digest] = true
+		}
+		return deleting
+	}
+	referrerKeepReason := func(image *ecr.ImageDetail, deleting map[string]bool) string {
+		digest := aws.StringValue(image.ImageDigest)
+		switch {
+		case !subjectDeleted(digest, subjects, deleting):
+			return "subject retained"
+		case image.ImagePushedAt == nil:
+			return "no push time"
+		case !c.cfg.DeleteUntagged:
+			return "untagged deletion disabled"
+		case c.cfg.inGracePeriod(image):
+			return "within grace period"
+		case !c.cfg.withinCap(c.Region, repoName, digest):
+			return "over delete cap"
+		}
+		return ""
+	}
+
+	// A repository over the per-repository cap is left untouched. The
+	// deletions awaiting approval count too, so that none is asked for in a
+	// repository that is then skipped; a dry run still logs its plan.
+	candidates := len(untaggedToDelete) + len(oldToDelete) + len(confirm.pending())
+	slated := deleting()
+	for _, image := range referrers {
+		if referrerKeepReason(image, slated) == "" {
+			candidates++
+		}
+	}
+	var capErr error
+	if c.cfg.MaxDeletePerRepo > 0 && candidates > c.cfg.MaxDeletePerRepo {
+		capErr = fmt.Errorf("%d images slated for deletion exceeds -max-delete-per-repo %d; nothing was deleted",
+			candidates, c.cfg.MaxDeletePerRepo)
+		if !c.cfg.DryRun {
+			return repoSummary, capErr
+		}
+	}
+	confirm.settle()
+
+	slated = deleting()
+	for _, image := range referrers {
+		digest := aws.StringValue(image.ImageDigest)
+		switch reason := referrerKeepReason(image, slated); reason {
+		case "":
+			confirm.request(fmt.Sprintf("%s (referrer of %s)", digest, subjects[digest]), digest, func(approved bool) {
+				if !approved {
+					c.logImage(logging.LevelInfo, actionKeep, repoName, digest, "✅ Referrer retained (not approved): %s | Subject: %s", digest, subjects[digest])
+					decide(image, decisionKeep, "not approved")
+					reviewAt(time.Now())
+					return
+				}
+				c.logImage(logging.LevelInfo, actionDelete, repoName, digest, "🗑️ Referrer to delete: %s | Subject: %s | Size: %s",
+					digest, subjects[digest], FormatBytes(aws.Int64Value(image.ImageSizeInBytes)))
+				decide(image, c.cfg.deleteDecision(), "subject deleted")
+				untaggedToDelete = append(untaggedToDelete, &ecr.ImageIdentifier{ImageDigest: image.ImageDigest})
+			})
+		case "subject retained", "untagged deletion disabled":
+			c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Referrer retained (%s): %s | Subject: %s", reason, digest, subjects[digest])
+			decide(image, decisionKeep, reason)
+		case "no push time":
+			decide(image, decisionKeep, reason)
+		case "within grace period":
+			decide(image, decisionKeep, reason)
+			reviewAt(image.ImagePushedAt.Add(c.cfg.gracePeriodFor(image)))
+		default:
+			decide(image, decisionKeep, reason)
+			reviewAt(time.Now())
+		}
+	}
+	confirm.settle()
+
+	if c.cfg.DryRun && !c.cfg.ReportOnly {
+		repoSummary.Plan = plan
+		c.logRepo(logging.LevelInfo, repoName, "%s", formatPlan(repoName, plan))
+		if c.cfg.CompareLifecycle != nil {
+			repoSummary.Lifecycle = c.compareLifecycle(repoName, imageDetails, plan)
+		}
+	}
+	if capErr != nil {
+		return repoSummary, capErr
+	}
+
+	// Step 10: Delete the collected images in batches. Old tagged images
+	// go before untagged ones, and manifest lists before other images, so
+	// that parents are deleted before the child manifests they reference.
+	untagged := deleteResult{deleted: untaggedToDelete}
+	old := deleteResult{deleted: oldToDelete}
+	tagsResult := deleteResult{deleted: tagsToDelete}
+	if !c.cfg.DryRun {
+		if len(oldToDelete) > 0 {
+			old = c.deleteListsFirst(ctx, repoName, oldToDelete, manifestLists)
+		}
+		if len(untaggedToDelete) > 0 {
+			untagged = c.deleteListsFirst(ctx, repoName, untaggedToDelete, manifestLists)
+		}
+		if len(tagsToDelete) > 0 {
+			tagsResult = c.deleteImages(ctx, repoName, tagsToDelete)
+		}
+	} else if c.cfg.Explain {
+		c.explainDeletes(repoName, oldToDelete, untaggedToDelete, tagsToDelete, manifestLists)
+	}
+
+	repoSummary.UntaggedDeleted = len(untagged.deleted)
+	repoSummary.Deleted = len(untagged.deleted) + len(old.deleted)
+	repoSummary.TagsDeleted = len(tagsResult.deleted)
+	repoSummary.Deferred = untagged.deferred + old.deferred
+	if repoSummary.Deferred > 0 {
+		reviewAt(time.Now())
+	}
+	repoSummary.FailedImages = append(append(untagged.failures, old.failures...), tagsResult.failures...)
+	repoSummary.Failed = len(repoSummary.FailedImages)
+	// Images that failed to delete are still in the repository, as are
+	// the deferred children. A failed tag removal leaves the image retained
+	// either way.
+	repoSummary.Retained = repoSummary.Scanned - len(untaggedToDelete) - len(oldToDelete) + repoSummary.Deferred +
+		len(untagged.failures) + len(old.failures)
+	for _, id := range append(untagged.deleted, old.deleted...) {
+		repoSummary.ReclaimedBytes += imageSizes[aws.StringValue(id.ImageDigest)]
+	}
+	if repoSummary.Deleted > 0 {
+		c.logRepo(logging.LevelInfo, repoName, "%s %s from %s", c.cfg.reclaimVerb(), FormatBytes(repoSummary.ReclaimedBytes), repoName)
+	}
+
+	// The boundary shows whether the retention window behaves as intended
+	repoSummary.OldestKept, repoSummary.NewestDeleted = retentionBoundary(imageDetails, append(untagged.deleted, old.deleted...))
+	c.logRepo(logging.LevelInfo, repoName, "Retention boundary in %s: oldest kept %s, newest deleted %s",
+		repoName, formatAge(repoSummary.OldestKept), formatAge(repoSummary.NewestDeleted))
+	return repoSummary, nil
+}
+
+// retentionBoundary returns the push time of the oldest image that is kept
+// and of the newest image that is deleted, either of which is nil if there
+// is no such image.
+func retentionBoundary(images []*ecr.ImageDetail, deleted []*ecr.ImageIdentifier) (oldestKept, newestDeleted *time.Time) {
+	deletedDigests := make(map[string]bool, len(deleted))
+	for _, id := range deleted {
+		deletedDigests[aws.StringValue(id.ImageDigest)] = true
+	}
+	for _, image := range images {
+		pushed := image.ImagePushedAt
+		if pushed == nil {
+			continue
+		}
+		if deletedDigests[aws.StringValue(image.ImageDigest)] {
+			if newestDeleted == nil || pushed.After(*newestDeleted) {
+				newestDeleted = pushed
+			}
+		} else if oldestKept == nil || pushed.Before(*oldestKept) {
+			oldestKept = pushed
+		}
+	}
+	return oldestKept, newestDeleted
+}
+
+// formatAge describes how many days ago t was, or "none" if t is nil.
+func formatAge(t *time.Time) string {
+	if t == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d days ago", int(time.Since(*t).Hours()/24))
+}
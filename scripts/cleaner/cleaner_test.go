@@ -0,0 +1,531 @@
+package cleaner
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestRetentionSelection(t *testing.T) {
+	prod, dev := PrefixMatcher("prod-"), PrefixMatcher("dev-")
+	tests := []struct {
+		name        string
+		cfg         Config
+		images      []*ecr.ImageDetail
+		wantDeleted []string
+	}{
+		{
+			name: "keeps the newest images per prefix",
+			cfg:  Config{Retention: 30, Keep: 2, Matchers: []TagMatcher{prod}},
+			images: []*ecr.ImageDetail{
+				image("sha256:p1", 90, "prod-1"), image("sha256:p2", 80, "prod-2"), image("sha256:p3", 70, "prod-3"),
+			},
+			wantDeleted: []string{"sha256:p1"},
+		},
+		{
+			name: "keeps images within the retention",
+			cfg:  Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{prod}, IncludeUnmatched: true},
+			images: []*ecr.ImageDetail{
+				image("sha256:p1", 20, "prod-1"), image("sha256:p2", 10, "prod-2"), image("sha256:other", 40, "other"),
+			},
+			wantDeleted: []string{"sha256:other"},
+		},
+		{
+			name: "keep map overrides the keep count",
+			cfg:  Config{Retention: 30, Keep: 1, KeepMap: map[string]int{"prod-": 2}, Matchers: []TagMatcher{prod, dev}},
+			images: []*ecr.ImageDetail{
+				image("sha256:p1", 90, "prod-1"), image("sha256:p2", 80, "prod-2"), image("sha256:p3", 70, "prod-3"),
+				image("sha256:d1", 90, "dev-1"), image("sha256:d2", 80, "dev-2"),
+			},
+			wantDeleted: []string{"sha256:d1", "sha256:p1"},
+		},
+		{
+			name: "protected tags are never deleted",
+			cfg:  Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("latest")}, ProtectTags: map[string]bool{"release-stable": true}, IncludeUnmatched: true},
+			images: []*ecr.ImageDetail{
+				image("sha256:release", 90, "release-stable"), image("sha256:build", 90, "build-7"), image("sha256:latest", 1, "latest"),
+			},
+			wantDeleted: []string{"sha256:build"},
+		},
+		{
+			name: "protected digests beat every other rule",
+			cfg:  Config{Retention: 30, Keep: 1, DeleteUntagged: true, IncludeUnmatched: true, ProtectDigests: map[string]bool{"sha256:pinned": true, "sha256:base": true}},
+			images: []*ecr.ImageDetail{
+				image("sha256:pinned", 400), image("sha256:base", 200, "base-2019"), image("sha256:stray", 400), image("sha256:recent", 2, "base-2024"),
+			},
+			wantDeleted: []string{"sha256:stray"},
+		},
+		{
+			name:        "untagged images only with DeleteUntagged",
+			cfg:         Config{Retention: 30, Keep: 1},
+			images:      []*ecr.ImageDetail{image("sha256:untagged", 1)},
+			wantDeleted: nil,
+		},
+		{
+			name:        "untagged images of any age",
+			cfg:         Config{Retention: 30, Keep: 1, DeleteUntagged: true},
+			images:      []*ecr.ImageDetail{image("sha256:untagged", 1)},
+			wantDeleted: []string{"sha256:untagged"},
+		},
+		{
+			name: "before cutoff must also pass",
+			cfg:  Config{Retention: 30, Keep: 1, Before: time.Now().AddDate(0, 0, -60), CutoffMode: CutoffAnd, IncludeUnmatched: true},
+			images: []*ecr.ImageDetail{
+				image("sha256:older", 90, "a"), image("sha256:old", 45, "b"),
+			},
+			wantDeleted: []string{"sha256:older"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeECR{images: tt.images}
+			if _, err := New(fake, tt.cfg).cleanRepository(context.Background(), "app"); err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(fake.deleted)
+			if !slices.Equal(fake.deleted, tt.wantDeleted) {
+				t.Errorf("deleted %v, want %v", fake.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestRunListsEveryRepositoryPage(t *testing.T) {
+	// Three repositories span two pages of the fake
+	fake := &fakeECR{repos: []string{"app", "web", "worker"}}
+	summary, err := New(fake, Config{Concurrency: 2}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, repo := range summary.Repositories {
+		names = append(names, repo.Repository)
+	}
+	if !slices.Equal(names, fake.repos) {
+		t.Errorf("processed %v, want %v", names, fake.repos)
+	}
+}
+
+func TestOldestImagesAcrossPagesAreDeleted(t *testing.T) {
+	// The fake returns two images a page, so the oldest images sit on the
+	// second and third pages
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:a", 1, "v5"), image("sha256:b", 2, "v4"),
+		image("sha256:c", 3, "v3"), image("sha256:d", 40, "v2"),
+		image("sha256:e", 90, "v1"),
+	}}
+	summary, err := New(fake, Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("v")}}).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sha256:d", "sha256:e"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted %v, want %v", fake.deleted, want)
+	}
+	if summary.Scanned != 5 || summary.Deleted != 2 {
+		t.Errorf("scanned %d and deleted %d, want 5 and 2", summary.Scanned, summary.Deleted)
+	}
+}
+
+func TestImageWithTwoMatchingTagsCountsOnce(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:newest", 40, "prod-3", "prod-3-hotfix"),
+		image("sha256:middle", 50, "prod-2"),
+		image("sha256:oldest", 60, "prod-1"),
+	}}
+	cfg := Config{Retention: 30, Keep: 2, Matchers: []TagMatcher{PrefixMatcher("prod-")}}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both tags of the newest image match, yet it fills one of the two
+	// slots, leaving the other to the middle image
+	if !slices.Equal(fake.deleted, []string{"sha256:oldest"}) || summary.Retained != 2 {
+		t.Errorf("deleted %v and retained %d, want only the oldest image deleted", fake.deleted, summary.Retained)
+	}
+}
+
+func TestFailedDeletionsAreCountedAsRetained(t *testing.T) {
+	fake := &fakeECR{
+		images: []*ecr.ImageDetail{
+			image("sha256:new", 1, "v3"), image("sha256:old", 60, "v2"),
+			image("sha256:bad", 60, "v1"), image("sha256:gone", 60, "v0"),
+		},
+		fail: map[string][]string{
+			"sha256:bad":  {ecr.ImageFailureCodeInvalidImageDigest},
+			"sha256:gone": {ecr.ImageFailureCodeImageNotFound},
+		},
+	}
+	summary, err := New(fake, Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("v")}}).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An image another run deleted first is gone, not failed
+	want := ImageCounts{Scanned: 4, Retained: 2, Deleted: 1, Failed: 1, ReclaimedBytes: 1 << 20}
+	if summary.ImageCounts != want {
+		t.Errorf("counts = %+v, want %+v", summary.ImageCounts, want)
+	}
+}
+
+func TestMinKeepFloorKeepsTheNewestImage(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:older", 90, "build-1"), image("sha256:newest", 40, "build-3"), image("sha256:old", 60, "build-2"),
+	}}
+	summary, err := New(fake, Config{Retention: 30, MinKeep: 1, IncludeUnmatched: true, DryRun: true}).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reasons(summary)
+	want := map[string]string{
+		"sha256:newest": "minimum keep floor",
+		"sha256:old":    "older than retention",
+		"sha256:older":  "older than retention",
+	}
+	for digest, reason := range want {
+		if got[digest] != reason {
+			t.Errorf("%s: %q, want %q", digest, got[digest], reason)
+		}
+	}
+}
+
+func TestDeleteByTagOnSharedDigests(t *testing.T) {
+	images := func() []*ecr.ImageDetail {
+		return []*ecr.ImageDetail{
+			image("sha256:shared", 60, "dev-123", "prod-pinned", "dev-123-arm"),
+			image("sha256:dev", 60, "dev-122", "dev-122-arm"),
+			image("sha256:new", 1, "dev-124"),
+		}
+	}
+	cfg := Config{
+		Retention:   30,
+		Keep:        1,
+		Matchers:    []TagMatcher{PrefixMatcher("dev-")},
+		ProtectTags: map[string]bool{"prod-pinned": true},
+		DeleteByTag: true,
+	}
+
+	t.Run("protected digest loses only its other tags", func(t *testing.T) {
+		fake := &fakeECR{images: images()}
+		summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(fake.deleted)
+		if want := []string{"sha256:dev", "tag:dev-123", "tag:dev-123-arm"}; !slices.Equal(fake.deleted, want) {
+			t.Errorf("deleted %v, want %v", fake.deleted, want)
+		}
+		if summary.Deleted != 1 || summary.TagsDeleted != 2 || summary.Retained != 2 {
+			t.Errorf("deleted %d images and %d tags, retained %d; want 1, 2 and 2", summary.Deleted, summary.TagsDeleted, summary.Retained)
+		}
+	})
+
+	t.Run("scan findings keep every tag", func(t *testing.T) {
+		fake := &fakeECR{images: images(), findings: map[string]string{"sha256:shared": ecr.FindingSeverityCritical}}
+		quarantine := cfg
+		quarantine.MinSeverity = ecr.FindingSeverityHigh
+		if _, err := New(fake, quarantine).cleanRepository(context.Background(), "app"); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"sha256:dev"}; !slices.Equal(fake.deleted, want) {
+			t.Errorf("deleted %v, want %v", fake.deleted, want)
+		}
+	})
+
+	t.Run("small digest keeps every tag", func(t *testing.T) {
+		fake := &fakeECR{images: images()}
+		small := cfg
+		small.MinSize = 2 << 20
+		if _, err := New(fake, small).cleanRepository(context.Background(), "app"); err != nil {
+			t.Fatal(err)
+		}
+		if len(fake.deleted) != 0 {
+			t.Errorf("deleted %v, want nothing under the minimum size", fake.deleted)
+		}
+	})
+}
+
+func TestEqualPushTimesKeepTheSameImage(t *testing.T) {
+	a, b := image("sha256:aaa", 40, "build-a"), image("sha256:bbb", 40, "build-b")
+	b.ImagePushedAt = a.ImagePushedAt
+	cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("build-")}}
+	// Whatever order ECR lists them in, the lower digest is kept
+	for _, images := range [][]*ecr.ImageDetail{{a, b}, {b, a}} {
+		fake := &fakeECR{images: images}
+		if _, err := New(fake, cfg).cleanRepository(context.Background(), "app"); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(fake.deleted, []string{"sha256:bbb"}) {
+			t.Errorf("listing %s first deleted %v, want sha256:bbb", aws.StringValue(images[0].ImageDigest), fake.deleted)
+		}
+	}
+}
+
+func TestIncludeUnmatched(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		fake := &fakeECR{images: []*ecr.ImageDetail{
+			image("sha256:prod", 60, "prod-1"), image("sha256:prod-new", 50, "prod-2"),
+			image("sha256:feature", 60, "feature-x"),
+		}}
+		cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("prod-")}, IncludeUnmatched: include, DryRun: true}
+		summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The prefix keeps its newest image either way; only the image
+		// no prefix matches depends on the flag
+		want := map[string]string{
+			"sha256:prod-new": "latest tag-match",
+			"sha256:prod":     "older than retention",
+			"sha256:feature":  "no matching prefix",
+		}
+		if include {
+			want["sha256:feature"] = "older than retention"
+		}
+		got := reasons(summary)
+		for digest, reason := range want {
+			if got[digest] != reason {
+				t.Errorf("IncludeUnmatched %t: %s %q, want %q", include, digest, got[digest], reason)
+			}
+		}
+	}
+}
+
+func TestMaxDeletePerRepoLeavesTheRepositoryUntouched(t *testing.T) {
+	// Two of the images are slated for deletion
+	images := []*ecr.ImageDetail{image("sha256:a", 60, "v1"), image("sha256:b", 50, "v2"), image("sha256:c", 1, "v3")}
+	for _, tt := range []struct {
+		limit       int
+		wantDeleted int
+		wantErr     bool
+	}{
+		{limit: 1, wantDeleted: 0, wantErr: true},
+		{limit: 2, wantDeleted: 2},
+	} {
+		fake := &fakeECR{images: images}
+		cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("v")}, MaxDeletePerRepo: tt.limit}
+		_, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("limit %d: err = %v, want an error %t", tt.limit, err, tt.wantErr)
+		}
+		if len(fake.deleted) != tt.wantDeleted {
+			t.Errorf("limit %d: deleted %v, want %d images", tt.limit, fake.deleted, tt.wantDeleted)
+		}
+	}
+}
+
+func TestGracePeriodProtectsRecentImages(t *testing.T) {
+	hoursAgo := func(digest string, hours int, tag string) *ecr.ImageDetail {
+		image := image(digest, 0, tag)
+		image.ImagePushedAt = aws.Time(time.Now().Add(-time.Duration(hours) * time.Hour))
+		return image
+	}
+	for _, tt := range []struct {
+		grace time.Duration
+		want  []string
+	}{
+		{0, []string{"sha256:old", "sha256:recent"}},
+		{24 * time.Hour, []string{"sha256:old"}},
+	} {
+		fake := &fakeECR{images: []*ecr.ImageDetail{
+			hoursAgo("sha256:newest", 1, "build-3"), hoursAgo("sha256:recent", 5, "build-2"), hoursAgo("sha256:old", 60*24, "build-1"),
+		}}
+		// The -before cutoff of now expires everything the keep count
+		// leaves, however recent
+		cfg := Config{
+			Retention: 30, Before: time.Now(), CutoffMode: CutoffOr,
+			Keep: 1, Matchers: []TagMatcher{PrefixMatcher("build-")},
+			GracePeriod: tt.grace,
+		}
+		if _, err := New(fake, cfg).cleanRepository(context.Background(), "app"); err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(fake.deleted)
+		if !slices.Equal(fake.deleted, tt.want) {
+			t.Errorf("grace period %s: deleted %v, want %v", tt.grace, fake.deleted, tt.want)
+		}
+	}
+}
+
+func TestTagCountThreshold(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:base", 90, "base", "node", "node-20", "node-20-slim", "lts"),
+		image("sha256:app", 90, "app-1"),
+	}}
+	cfg := Config{Retention: 30, IncludeUnmatched: true, TagCountThreshold: 3, DryRun: true}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range summary.Plan {
+		deleted := d.Decision != decisionKeep
+		if want := d.Digest == "sha256:app"; deleted != want {
+			t.Errorf("%s with %d tags: %s (%s), want deleted %t", d.Digest, len(d.Tags), d.Decision, d.Reason, want)
+		}
+	}
+	if len(summary.Plan) != 2 {
+		t.Errorf("plan has %d decisions, want 2", len(summary.Plan))
+	}
+}
+
+func TestDeleteEmptyRepositories(t *testing.T) {
+	fake := &fakeECR{
+		repos:  []string{"team/app", "team/tmp"},
+		images: []*ecr.ImageDetail{image("sha256:old", 60, "build-1")},
+	}
+	cfg := Config{Retention: 30, IncludeUnmatched: true, Concurrency: 1, DeleteEmptyRepos: []string{"team/tmp*"}}
+	summary, err := New(fake, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both repositories are emptied, but only one matches the patterns
+	if want := []string{"sha256:old", "sha256:old", "repository:team/tmp"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted %v, want %v", fake.deleted, want)
+	}
+	for _, repo := range summary.Repositories {
+		if want := repo.Repository == "team/tmp"; repo.RepositoryDeleted != want {
+			t.Errorf("%s: repository deleted %t, want %t", repo.Repository, repo.RepositoryDeleted, want)
+		}
+	}
+}
+
+func TestUnpulledDaysAgesByLastPull(t *testing.T) {
+	pulled := func(img *ecr.ImageDetail, days int) *ecr.ImageDetail {
+		at := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		img.LastRecordedPullTime = &at
+		return img
+	}
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		pulled(image("sha256:old-pulled", 90, "a"), 2),
+		pulled(image("sha256:new-unpulled", 5, "b"), 20),
+		image("sha256:never-pulled", 40, "c"),
+		pulled(image("sha256:untagged", 90), 1),
+	}}
+	cfg := Config{Retention: 30, UnpulledDays: 10, IncludeUnmatched: true, DeleteUntagged: true, DryRun: true}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"sha256:old-pulled":   "pulled recently",
+		"sha256:new-unpulled": "not pulled recently",
+		"sha256:never-pulled": "older than retention",
+		"sha256:untagged":     "pulled recently",
+	}
+	if got := reasons(summary); !maps.Equal(got, want) {
+		t.Errorf("reasons %v, want %v", got, want)
+	}
+}
+
+func TestExcludedImagesDoNotUseUpTheKeepCount(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:hotfix", 40, "prod-hotfix-7"),
+		image("sha256:prod-2", 50, "prod-2"),
+		image("sha256:prod-1", 60, "prod-1"),
+	}}
+	cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("prod-")}, ExcludePrefixes: []string{"prod-hotfix"}}
+	if _, err := New(fake, cfg).cleanRepository(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	// The hotfix is kept, and prod-2 still counts as the newest prod- image
+	if want := []string{"sha256:prod-1"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted %v, want %v", fake.deleted, want)
+	}
+}
+
+func TestSharedDigestDoesNotUseUpALaterPrefix(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:shared", 40, "latest", "prod-3"),
+		image("sha256:prod-2", 50, "prod-2"),
+		image("sha256:prod-1", 60, "prod-1"),
+	}}
+	cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("latest"), PrefixMatcher("prod-")}}
+	if _, err := New(fake, cfg).cleanRepository(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	// latest keeps the shared digest, and prod- still keeps one of its own
+	if want := []string{"sha256:prod-1"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted %v, want %v", fake.deleted, want)
+	}
+}
+
+func TestProcessRepository(t *testing.T) {
+	tests := []struct {
+		name   string
+		images []*ecr.ImageDetail
+		fail   map[string][]string
+		config func(*Config)
+		// want is the ImageCounts of the summary, nil when there is none.
+		want        *ImageCounts
+		wantFailed  []string
+		wantDeleted bool
+		wantErr     bool
+	}{
+		{
+			name: "deletes and counts",
+			images: []*ecr.ImageDetail{
+				image("sha256:old", 60, "v1"), image("sha256:new", 1, "v2"),
+				image("sha256:untagged", 40), image("sha256:bad", 50, "v0"),
+			},
+			fail:       map[string][]string{"sha256:bad": {ecr.ImageFailureCodeInvalidImageDigest}},
+			want:       &ImageCounts{Scanned: 4, Retained: 2, Deleted: 2, UntaggedDeleted: 1, Failed: 1, ReclaimedBytes: 2 << 20},
+			wantFailed: []string{"sha256:bad"},
+		},
+		{
+			name:   "over the per-repository cap",
+			images: []*ecr.ImageDetail{image("sha256:old", 60, "v1"), image("sha256:untagged", 40)},
+			config: func(cfg *Config) { cfg.MaxDeletePerRepo = 1 },
+			// The repository fails, so there is no summary
+			wantErr: true,
+		},
+		{
+			name:        "deletes the emptied repository",
+			images:      []*ecr.ImageDetail{image("sha256:old", 60, "v1")},
+			config:      func(cfg *Config) { cfg.DeleteEmptyRepos = []string{"app"} },
+			want:        &ImageCounts{Scanned: 1, Deleted: 1, ReclaimedBytes: 1 << 20},
+			wantDeleted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeECR{images: tt.images, fail: tt.fail}
+			cfg := Config{Retention: 30, Matchers: []TagMatcher{PrefixMatcher("v")}, DeleteUntagged: true}
+			if tt.config != nil {
+				tt.config(&cfg)
+			}
+			result := New(fake, cfg).processRepository(context.Background(), &ecr.Repository{RepositoryName: aws.String("app")})
+
+			if (result.Err != nil) != tt.wantErr {
+				t.Fatalf("Err = %v, want error %t", result.Err, tt.wantErr)
+			}
+			if result.Skipped {
+				t.Error("the repository was skipped")
+			}
+			if tt.want == nil {
+				if result.Summary != nil {
+					t.Errorf("Summary = %+v, want none", result.Summary)
+				}
+				return
+			}
+			if result.Summary == nil {
+				t.Fatal("no Summary")
+			}
+			if result.Summary.ImageCounts != *tt.want {
+				t.Errorf("ImageCounts = %+v, want %+v", result.Summary.ImageCounts, *tt.want)
+			}
+			var failed []string
+			for _, f := range result.Summary.FailedImages {
+				failed = append(failed, f.Digest)
+			}
+			if !slices.Equal(failed, tt.wantFailed) {
+				t.Errorf("FailedImages = %v, want %v", failed, tt.wantFailed)
+			}
+			if result.Summary.RepositoryDeleted != tt.wantDeleted {
+				t.Errorf("RepositoryDeleted = %t, want %t", result.Summary.RepositoryDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
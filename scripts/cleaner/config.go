@@ -0,0 +1,533 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// Supported values for Config.CutoffMode.
+const (
+	CutoffAnd = "and"
+	CutoffOr  = "or"
+)
+
+// Supported values for Config.SortOrder.
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// Config is the retention policy applied by a Cleaner.
+type Config struct {
+	// Retention is the age in days past which images become deletion
+	// candidates. Untagged images use UntaggedRetention instead, where
+	// zero deletes them regardless of age. RetentionDuration, when
+	// positive, replaces Retention with a window of any length, so images
+	// become candidates once older than it rather than after whole days.
+	Retention         int
+	RetentionDuration time.Duration
+	UntaggedRetention int
+	// Before, when non-zero, is an absolute cutoff date. It is combined
+	// with Retention according to CutoffMode.
+	Before     time.Time
+	CutoffMode string
+
+	// UnpulledDays, when positive, ages the images that have been pulled
+	// by their last recorded pull instead of their push: they expire once
+	// not pulled for this many days, whatever Retention and Before say.
+	// Images that were never pulled still expire by push time.
+	UnpulledDays int
+	// AgeFromTag, when set, ages tagged images by a date encoded in a tag,
+	// such as build-20240115, instead of their push time, which re-pushes
+	// and re-tags reset. The first capture group, or the whole match, is
+	// the date. Images with no tag it can read a date from fall back to
+	// their push time.
+	AgeFromTag *regexp.Regexp
+
+	// MinSize, when positive, limits deletion candidates to images larger
+	// than this many bytes. Images without a size are kept.
+	MinSize int64
+
+	// GracePeriod protects images pushed more recently than this from
+	// deletion, regardless of the other rules. GraceMap overrides it for
+	// the images with a tag starting with one of its prefixes.
+	GracePeriod time.Duration
+	GraceMap    map[string]time.Duration
+
+	// Keep is the number of most recent images retained per matcher,
+	// unless KeepMap holds a count for the matcher's pattern. KeepByRepo
+	// holds counts for whole repositories, which win over both.
+	Keep       int
+	KeepMap    map[string]int
+	KeepByRepo map[string]int
+	Matchers   []TagMatcher
+	// KeepBy selects which images per matcher are kept: the most recently
+	// pushed (KeepByPushed, the default) or the highest semantic versions
+	// (KeepBySemver), or every image of the most recently pushed versions
+	// (KeepByVersion), so that the per-architecture images of a version
+	// are kept together. VersionPattern, when set, extracts the version
+	// from a tag instead of the semantic version.
+	KeepBy         string
+	VersionPattern *regexp.Regexp
+	// IncludeUnmatched subjects tagged images whose tags match none of the
+	// Matchers to the age cutoff. When false such images are kept.
+	IncludeUnmatched bool
+	// Rules, keyed by matcher pattern, override the keep count and
+	// retention of the images a matcher matches, or keep them all.
+	// DefaultRule, when set, applies to tagged images no matcher
+	// matches, which are then subject to it whatever IncludeUnmatched.
+	Rules       map[string]Rule
+	DefaultRule *Rule
+
+	// MinKeep is the minimum number of most recent images that survive in
+	// each repository regardless of age.
+	MinKeep int
+
+	// MediaTypes, when set, limits deletion to images whose manifest or
+	// artifact media type is listed; other images, such as Helm charts
+	// stored as OCI artifacts, are kept.
+	MediaTypes map[string]bool
+
+	// ProtectTags lists exact tags whose images are never deleted.
+	ProtectTags map[string]bool
+	// ProtectDigests lists image digests that are never deleted, whatever
+	// their tags and every other rule say.
+	ProtectDigests map[string]bool
+	// ExcludePrefixes lists tag prefixes whose images are never deleted,
+	// whatever the keep counts of the Matchers.
+	ExcludePrefixes []string
+	// TagCountThreshold, when positive, protects images carrying more
+	// than this many tags, which are usually shared base images.
+	TagCountThreshold int
+
+	// Repositories, when set, names the repositories to clean up instead
+	// of listing every repository in the region.
+	Repositories []string
+	// RepoFilter and RepoExclude are glob patterns matched against
+	// repository names.
+	RepoFilter  []string
+	RepoExclude []string
+	// ResourceTags, when set, limits the run to repositories carrying all
+	// of these AWS resource tags.
+	ResourceTags map[string]string
+	// ProtectRepos names repositories that are skipped entirely.
+	ProtectRepos map[string]bool
+	// SortOrder is the order in which repositories are processed: by
+	// name, ascending (SortAsc, the default) or descending (SortDesc).
+	SortOrder string
+
+	// MinSeverity, when set, keeps deletion candidates whose latest scan
+	// has findings at or above this severity (e.g. CRITICAL).
+	MinSeverity string
+
+	// SkipLifecycleManaged leaves repositories that have an ECR lifecycle
+	// policy untouched.
+	SkipLifecycleManaged bool
+
+	DeleteUntagged bool
+	// Incremental skips the repositories the State records as up to date:
+	// scanned within FullScanAfter, with no image kept for its age that
+	// may have expired since. A full scan of every repository at least
+	// every FullScanAfter catches the images displaced from the keep
+	// counts by newer pushes.
+	Incremental   bool
+	FullScanAfter time.Duration
+	// FollowReferrers deletes referrers, such as signatures and SBOMs
+	// attached to an image through the OCI subject field, together with
+	// the image they refer to, and keeps them while it is kept. It costs
+	// a BatchGetImage call per 100 untagged OCI manifests.
+	FollowReferrers bool
+	// DeleteEmptyRepos lists glob patterns of repositories that are
+	// deleted once the run leaves them without images.
+	DeleteEmptyRepos []string
+	// MaxDeletePerRepo, when positive, leaves a repository untouched and
+	// fails it if more images than this are slated for deletion.
+	MaxDeletePerRepo int
+	// MinRepos, when positive, fails the region before anything is
+	// processed if fewer repositories than this are listed or discovered,
+	// as a guard against credentials for the wrong account.
+	MinRepos int
+	// DeleteOnly, when set, limits deletion to the images it holds, keyed
+	// by CandidateKey, and keeps every other candidate. It trims a run to
+	// a cap on the total number of deletions.
+	DeleteOnly map[string]bool
+	// DeleteByTag removes the unprotected tags of expired images that are
+	// kept only for a protected tag. Deleting by tag identifier leaves
+	// the protected tags, and the image, in place.
+	DeleteByTag bool
+	DryRun      bool
+	// FailFast aborts the run on the first error instead of completing
+	// the sweep.
+	FailFast bool
+
+	// Concurrency is the number of repositories processed in parallel.
+	Concurrency int
+	// DeleteConcurrency is the number of delete batches in flight at once,
+	// within a repository and across the whole run.
+	DeleteConcurrency int
+	// MaxRetries is how many times images that fail to delete with a
+	// transient error are retried.
+	MaxRetries int
+
+	// ProgressInterval is how often a progress heartbeat is logged; zero
+	// disables it. Quiet suppresses the per-image log entries other than
+	// warnings and errors.
+	ProgressInterval time.Duration
+	Quiet            bool
+	// QuietEmptyRepos logs the "No images found" message for empty
+	// repositories at debug level, for accounts that keep some empty.
+	QuietEmptyRepos bool
+	// ReportOnly is a dry run that only counts the images and bytes that
+	// would be deleted, without collecting the per-image plan.
+	ReportOnly bool
+	// Explain logs, in a dry run, the BatchDeleteImage requests a real run
+	// would send.
+	Explain bool
+	// CompareLifecycle, when set, evaluates this ECR lifecycle policy
+	// against each repository in a dry run and reports the images on
+	// which it and the plan diverge.
+	CompareLifecycle *LifecyclePolicy
+}
+
+// isExpired reports whether an image pushed at the given time is past the
+// retention window and, when Before is set, the absolute cutoff date.
+// The two guards are combined according to CutoffMode.
+func (c Config) isExpired(pushedAt time.Time) bool {
+	return c.isExpiredAfter(pushedAt, c.retention())
+}
+
+// isExpiredAfter is isExpired with the given retention window.
+func (c Config) isExpiredAfter(pushedAt time.Time, retention time.Duration) bool {
+	pastRetention := time.Since(pushedAt) >= retention
+	if c.Before.IsZero() {
+		return pastRetention
+	}
+	pastBefore := pushedAt.Before(c.Before)
+	if c.CutoffMode == CutoffOr {
+		return pastRetention || pastBefore
+	}
+	return pastRetention && pastBefore
+}
+
+// agedFrom returns the time an image's age is counted from: the date
+// AgeFromTag reads from its tags, or else its push time. Of several tag
+// dates the latest wins, and dates in the future are ignored.
+func (c Config) agedFrom(image *ecr.ImageDetail) time.Time {
+	pushedAt := *image.ImagePushedAt
+	if c.AgeFromTag == nil {
+		return pushedAt
+	}
+	var latest time.Time
+	for _, tag := range aws.StringValueSlice(image.ImageTags) {
+		if date, ok := tagDate(c.AgeFromTag, tag); ok && date.After(latest) && !date.After(time.Now()) {
+			latest = date
+		}
+	}
+	if latest.IsZero() {
+		return pushedAt
+	}
+	return latest
+}
+
+// tagDateLayouts are the date formats tagDate accepts, in UTC.
+var tagDateLayouts = []string{"20060102", "2006-01-02", "2006.01.02", "20060102150405", "20060102-150405", time.RFC3339}
+
+// tagDate extracts a date from tag with re, whose first capture group, or
+// whole match, must be in one of tagDateLayouts.
+func tagDate(re *regexp.Regexp, tag string) (time.Time, bool) {
+	match := re.FindStringSubmatch(tag)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+	for _, layout := range tagDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// inGracePeriod reports whether the image is younger than its grace
+// period.
+func (c Config) inGracePeriod(image *ecr.ImageDetail) bool {
+	grace := c.gracePeriodFor(image)
+	return grace > 0 && time.Since(*image.ImagePushedAt) < grace
+}
+
+// gracePeriodFor returns the grace period of the image. Each tag takes the
+// GraceMap override of the longest prefix it starts with, or GracePeriod
+// if none matches; the image gets the longest of its tags' periods, so
+// an extra tag never shortens it. Untagged images use GracePeriod.
+func (c Config) gracePeriodFor(image *ecr.ImageDetail) time.Duration {
+	if len(c.GraceMap) == 0 || len(image.ImageTags) == 0 {
+		return c.GracePeriod
+	}
+	var longest time.Duration
+	for _, tag := range aws.StringValueSlice(image.ImageTags) {
+		grace, matched := c.GracePeriod, ""
+		for prefix, override := range c.GraceMap {
+			if strings.HasPrefix(tag, prefix) && len(prefix) > len(matched) {
+				grace, matched = override, prefix
+			}
+		}
+		longest = max(longest, grace)
+	}
+	return longest
+}
+
+// widelyTagged reports whether the image carries more tags than
+// TagCountThreshold.
+func (c Config) widelyTagged(image *ecr.ImageDetail) bool {
+	return c.TagCountThreshold > 0 && len(image.ImageTags) > c.TagCountThreshold
+}
+
+// excluded reports whether any of the image's tags starts with one of the
+// ExcludePrefixes.
+func (c Config) excluded(image *ecr.ImageDetail) bool {
+	for _, tag := range image.ImageTags {
+		for _, prefix := range c.ExcludePrefixes {
+			if strings.HasPrefix(aws.StringValue(tag), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withinCap reports whether DeleteOnly allows the image to be deleted.
+func (c Config) withinCap(region, repoName, digest string) bool {
+	return c.DeleteOnly == nil || c.DeleteOnly[CandidateKey(region, repoName, digest)]
+}
+
+// largeEnough reports whether the image is larger than MinSize, or
+// MinSize is not set.
+func (c Config) largeEnough(image *ecr.ImageDetail) bool {
+	if c.MinSize <= 0 {
+		return true
+	}
+	return image.ImageSizeInBytes != nil && *image.ImageSizeInBytes > c.MinSize
+}
+
+// untaggedExpired reports whether an untagged image is past the untagged
+// retention window, or the UnpulledDays window if it has been pulled.
+func (c Config) untaggedExpired(image *ecr.ImageDetail) bool {
+	if expired, ok := c.pullExpired(image); ok {
+		return expired
+	}
+	return c.UntaggedRetention == 0 || int(time.Since(*image.ImagePushedAt).Hours()/24) > c.UntaggedRetention
+}
+
+// untaggedExpiry returns when untaggedExpired starts to hold for the
+// untagged image.
+func (c Config) untaggedExpiry(image *ecr.ImageDetail) time.Time {
+	if _, ok := c.pullExpired(image); ok {
+		return expiresAfter(*image.LastRecordedPullTime, c.UnpulledDays)
+	}
+	return expiresAfter(*image.ImagePushedAt, c.UntaggedRetention)
+}
+
+// expiresAfter returns when something that happened at since becomes more
+// than the given number of whole days old.
+func expiresAfter(since time.Time, days int) time.Time {
+	return since.Add(daysWindow(days))
+}
+
+// daysWindow returns the age at which something becomes more than the
+// given number of whole days old.
+func daysWindow(days int) time.Duration {
+	return time.Duration(days+1) * 24 * time.Hour
+}
+
+// retention returns the retention window of images no rule gives a
+// maximum age: RetentionDuration if set, or else Retention days.
+func (c Config) retention() time.Duration {
+	if c.RetentionDuration > 0 {
+		return c.RetentionDuration
+	}
+	return daysWindow(c.Retention)
+}
+
+// pullExpired reports whether the image was last pulled more than
+// UnpulledDays ago. ok is false when the pull time does not decide: with
+// UnpulledDays unset, or for an image that was never pulled.
+func (c Config) pullExpired(image *ecr.ImageDetail) (expired, ok bool) {
+	if c.UnpulledDays <= 0 || image.LastRecordedPullTime == nil {
+		return false, false
+	}
+	return int(time.Since(*image.LastRecordedPullTime).Hours()/24) > c.UnpulledDays, true
+}
+
+// keepReason returns the report reason for an image kept for its age,
+// which is its last pull when that decided.
+func (c Config) keepReason(image *ecr.ImageDetail, reason string) string {
+	if _, ok := c.pullExpired(image); ok {
+		return "pulled recently"
+	}
+	return reason
+}
+
+// deleteReason returns the report reason for an image deleted for its
+// age, which is its last pull when that decided.
+func (c Config) deleteReason(image *ecr.ImageDetail, reason string) string {
+	if _, ok := c.pullExpired(image); ok {
+		return "not pulled recently"
+	}
+	return reason
+}
+
+// keepFor returns the number of images to keep for the given pattern in
+// the given repository. A count for the repository wins over the counts
+// for the pattern.
+func (c Config) keepFor(repoName, pattern string) int {
+	if n, ok := c.KeepByRepo[repoName]; ok {
+		return n
+	}
+	if rule, ok := c.Rules[pattern]; ok && rule.KeepCount != nil {
+		return *rule.KeepCount
+	}
+	if n, ok := c.KeepMap[pattern]; ok {
+		return n
+	}
+	return c.Keep
+}
+
+// keepUnmatched returns the number of images no matcher matches that the
+// default rule keeps in the given repository.
+func (c Config) keepUnmatched(repoName string) int {
+	if n, ok := c.KeepByRepo[repoName]; ok {
+		return n
+	}
+	if c.DefaultRule != nil && c.DefaultRule.KeepCount != nil {
+		return *c.DefaultRule.KeepCount
+	}
+	return c.Keep
+}
+
+// includesUnmatched reports whether tagged images that match no matcher
+// are subject to deletion.
+func (c Config) includesUnmatched() bool {
+	return c.IncludeUnmatched || c.DefaultRule != nil
+}
+
+// deleteDecision returns the report decision for an image slated for
+// deletion.
+func (c Config) deleteDecision() string {
+	if c.DryRun {
+		return decisionDryRun
+	}
+	return decisionDelete
+}
+
+// reclaimVerb describes reclaimed storage in the tense matching the mode.
+func (c Config) reclaimVerb() string {
+	if c.DryRun {
+		return "Would reclaim"
+	}
+	return "Reclaimed"
+}
+
+// keepUntilPrefix marks tags of the form keep-until-YYYY-MM-DD, which keep
+// an image until the end of the given day regardless of its age.
+const (
+	keepUntilPrefix = "keep-until-"
+	keepUntilLayout = "2006-01-02"
+)
+
+// parseKeepUntil reports whether tag is a keep-until tag and, if so, the
+// moment the image stops being kept. A tag with the prefix but an invalid
+// date returns an error.
+func parseKeepUntil(tag string) (time.Time, bool, error) {
+	value, ok := strings.CutPrefix(tag, keepUntilPrefix)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	date, err := time.Parse(keepUntilLayout, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected %sYYYY-MM-DD", keepUntilPrefix)
+	}
+	return date.AddDate(0, 0, 1), true, nil
+}
+
+// TagMatcher matches image tags against a pattern, either as a literal
+// prefix or as a compiled regular expression.
+type TagMatcher struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// PrefixMatcher returns a TagMatcher matching tags that start with prefix.
+func PrefixMatcher(prefix string) TagMatcher {
+	return TagMatcher{Pattern: prefix}
+}
+
+// RegexMatcher returns a TagMatcher matching tags against the regular
+// expression expr.
+func RegexMatcher(expr string) (TagMatcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return TagMatcher{}, err
+	}
+	return TagMatcher{Pattern: expr, re: re}, nil
+}
+
+// regexLinePrefix marks the lines of a matcher file that hold a regular
+// expression rather than a literal prefix.
+const regexLinePrefix = "re:"
+
+// LoadMatcherFile reads a file of tag matchers, one per line: a literal
+// prefix, or a regular expression after "re:". Lines are trimmed, and blank
+// lines and lines starting with # are ignored. The matchers are returned in
+// file order; an invalid regular expression is reported with its line.
+func LoadMatcherFile(name string) ([]TagMatcher, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var matchers []TagMatcher
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expr, ok := strings.CutPrefix(line, regexLinePrefix)
+		if !ok {
+			matchers = append(matchers, PrefixMatcher(line))
+			continue
+		}
+		matcher, err := RegexMatcher(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid tag regex %q: %w", name, i+1, expr, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// Matches reports whether the tag matches the pattern.
+func (m TagMatcher) Matches(tag string) bool {
+	if m.re != nil {
+		return m.re.MatchString(tag)
+	}
+	return strings.HasPrefix(tag, m.Pattern)
+}
+
+// matchesAny reports whether name matches any of the glob patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestKeepForPrecedence(t *testing.T) {
+	ptr := func(n int) *int { return &n }
+	cfg := Config{
+		Keep:        2,
+		KeepMap:     map[string]int{"prod-": 10},
+		KeepByRepo:  map[string]int{"team/api": 1},
+		DefaultRule: &Rule{KeepCount: ptr(4)},
+	}
+	tests := []struct {
+		repo, pattern string
+		want          int
+	}{
+		{"team/web", "prod-", 10},
+		{"team/web", "dev-", 2},
+		{"team/api", "prod-", 1},
+		{"team/api", "dev-", 1},
+	}
+	for _, tt := range tests {
+		if got := cfg.keepFor(tt.repo, tt.pattern); got != tt.want {
+			t.Errorf("keepFor(%q, %q) = %d, want %d", tt.repo, tt.pattern, got, tt.want)
+		}
+	}
+	if got := cfg.keepUnmatched("team/web"); got != 4 {
+		t.Errorf("keepUnmatched(team/web) = %d, want the default rule's 4", got)
+	}
+	if got := cfg.keepUnmatched("team/api"); got != 1 {
+		t.Errorf("keepUnmatched(team/api) = %d, want the repository's 1", got)
+	}
+}
+
+func TestLoadMatcherFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "keep.txt")
+	content := "# release builds\nprod-\n\nre:^v\\d+\\.\\d+$\n  dev-  \n"
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matchers, err := LoadMatcherFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []string
+	for _, m := range matchers {
+		patterns = append(patterns, m.Pattern)
+	}
+	if got, want := strings.Join(patterns, " "), `prod- ^v\d+\.\d+$ dev-`; got != want {
+		t.Fatalf("patterns = %q, want %q", got, want)
+	}
+	for tag, want := range map[string]bool{"prod-1": true, "v1.2": true, "v1.2.3": false, "dev-9": true, "xprod-1": false} {
+		matched := false
+		for _, m := range matchers {
+			matched = matched || m.Matches(tag)
+		}
+		if matched != want {
+			t.Errorf("%s matched %t, want %t", tag, matched, want)
+		}
+	}
+}
+
+func TestLoadMatcherFileReportsTheLine(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "keep.txt")
+	if err := os.WriteFile(name, []byte("prod-\nre:v(\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadMatcherFile(name)
+	if err == nil || !strings.Contains(err.Error(), name+":2:") {
+		t.Errorf("err = %v, want it to name line 2", err)
+	}
+}
+
+func TestRetentionDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		age  time.Duration
+		want bool
+	}{
+		{"days, inside the window", Config{Retention: 1}, 47 * time.Hour, false},
+		{"days, past the window", Config{Retention: 1}, 49 * time.Hour, true},
+		{"duration, inside the window", Config{RetentionDuration: 2 * time.Hour}, time.Hour, false},
+		{"duration, past the window", Config{RetentionDuration: 2 * time.Hour}, 3 * time.Hour, true},
+		{"duration wins over days", Config{Retention: 30, RetentionDuration: 90 * time.Minute}, 2 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.isExpired(time.Now().Add(-tt.age)); got != tt.want {
+				t.Errorf("isExpired(%s ago) = %v, want %v", tt.age, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGracePeriodFor(t *testing.T) {
+	cfg := Config{GracePeriod: 24 * time.Hour, GraceMap: map[string]time.Duration{
+		"release-":    168 * time.Hour,
+		"release-rc-": 12 * time.Hour,
+		"hotfix-":     0,
+	}}
+	tests := []struct {
+		tags []string
+		want time.Duration
+	}{
+		{[]string{"release-1.4"}, 168 * time.Hour},
+		{[]string{"release-rc-2"}, 12 * time.Hour},
+		{[]string{"hotfix-9"}, 0},
+		{[]string{"main-abc123"}, 24 * time.Hour},
+		{[]string{"hotfix-9", "release-1.4"}, 168 * time.Hour},
+		{[]string{"hotfix-9", "main-abc123"}, 24 * time.Hour},
+		{nil, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		image := &ecr.ImageDetail{ImageTags: aws.StringSlice(tt.tags)}
+		if got := cfg.gracePeriodFor(image); got != tt.want {
+			t.Errorf("gracePeriodFor(%v) = %s, want %s", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestAgedFrom(t *testing.T) {
+	re := regexp.MustCompile(`nightly-(\d{8}|\d{4}\.\d{2}\.\d{2})`)
+	pushedAt := time.Date(2025, 6, 30, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		tags []string
+		want time.Time
+	}{
+		{"compact date", []string{"nightly-20250412"}, time.Date(2025, 4, 12, 0, 0, 0, 0, time.UTC)},
+		{"dotted date", []string{"stable", "nightly-2025.04.12"}, time.Date(2025, 4, 12, 0, 0, 0, 0, time.UTC)},
+		{"latest of several dates", []string{"nightly-20250301", "nightly-20250520"}, time.Date(2025, 5, 20, 0, 0, 0, 0, time.UTC)},
+		{"no date", []string{"nightly-current"}, pushedAt},
+		{"invalid date", []string{"nightly-20250230"}, pushedAt},
+		{"date in the future", []string{"nightly-" + time.Now().AddDate(0, 1, 0).Format("20060102")}, pushedAt},
+		{"untagged", nil, pushedAt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{AgeFromTag: re}
+			image := image("sha256:n", 0, tt.tags...)
+			image.ImagePushedAt = &pushedAt
+			if got := cfg.agedFrom(image); !got.Equal(tt.want) {
+				t.Errorf("agedFrom(%v) = %s, want %s", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgedFromWithoutPattern(t *testing.T) {
+	image := image("sha256:n", 5, "nightly-20250412")
+	if got := (Config{}).agedFrom(image); !got.Equal(*image.ImagePushedAt) {
+		t.Errorf("agedFrom = %s, want the push time %s", got, *image.ImagePushedAt)
+	}
+}
@@ -0,0 +1,118 @@
+package cleaner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// answer is an operator's reply to a deletion prompt.
+type answer int
+
+const (
+	answerNo answer = iota
+	answerYes
+	// answerAll approves the rest of the current repository.
+	answerAll
+)
+
+// Prompter asks an operator to approve each deletion. It is safe for
+// concurrent use, but prompts from parallel workers interleave, so it is
+// meant for runs that process one repository at a time.
+type Prompter struct {
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPrompter returns a Prompter reading replies from in and writing the
+// prompts to out.
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// ask prints the question and reads the reply. Anything other than y, yes,
+// a or all, including an empty line or EOF, is a no.
+func (p *Prompter) ask(question string) answer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.out, "%s [y/N/a] ", question)
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(p.out)
+		return answerNo
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return answerYes
+	case "a", "all":
+		return answerAll
+	}
+	return answerNo
+}
+
+// confirmer approves the deletions of a single repository, remembering an
+// "all" reply for the rest of it. Requests wait in a queue until settle, so
+// that the deletions of a repository can be counted before any is asked for.
+type confirmer struct {
+	prompter *Prompter
+	repoName string
+	all      bool
+	queue    []approvalRequest
+}
+
+// approvalRequest is a deletion waiting for a reply. digest is the image
+// deleted, or empty for the removal of a tag.
+type approvalRequest struct {
+	what   string
+	digest string
+	done   func(approved bool)
+}
+
+// request queues the deletion described by what, passing the reply to done
+// once settled. Without a prompter the deletion is approved at once.
+func (c *confirmer) request(what, digest string, done func(approved bool)) {
+	if c.prompter == nil {
+		done(true)
+		return
+	}
+	c.queue = append(c.queue, approvalRequest{what: what, digest: digest, done: done})
+}
+
+// pending returns the digests of the queued image deletions.
+func (c *confirmer) pending() []string {
+	var digests []string
+	for _, r := range c.queue {
+		if r.digest != "" {
+			digests = append(digests, r.digest)
+		}
+	}
+	return digests
+}
+
+// settle asks for the queued deletions in order.
+func (c *confirmer) settle() {
+	queue := c.queue
+	c.queue = nil
+	for _, r := range queue {
+		r.done(c.approve(r.what))
+	}
+}
+
+// approve reports whether the deletion described by what may go ahead. It
+// always does when there is no prompter.
+func (c *confirmer) approve(what string) bool {
+	if c.prompter == nil || c.all {
+		return true
+	}
+	switch c.prompter.ask(fmt.Sprintf("Delete %s %s?", c.repoName, what)) {
+	case answerAll:
+		c.all = true
+		return true
+	case answerYes:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestConfirmEachAsksOnlyWithinTheRepoCap(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{image("sha256:a", 40), image("sha256:b", 50)}}
+	var out bytes.Buffer
+	c := New(fake, Config{Retention: 30, DeleteUntagged: true, MaxDeletePerRepo: 1})
+	c.Prompter = NewPrompter(strings.NewReader("y\ny\n"), &out)
+
+	if _, err := c.cleanRepository(context.Background(), "app"); err == nil {
+		t.Fatal("cleanRepository succeeded over -max-delete-per-repo")
+	}
+	if out.Len() > 0 {
+		t.Errorf("asked for a repository over the cap: %q", out.String())
+	}
+	if len(fake.deleted) > 0 {
+		t.Errorf("deleted %v", fake.deleted)
+	}
+}
+
+func TestConfirmEachKeepsDeclinedImages(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{image("sha256:a", 40), image("sha256:b", 50)}}
+	var out bytes.Buffer
+	c := New(fake, Config{Retention: 30, DeleteUntagged: true, MaxDeletePerRepo: 2})
+	c.Prompter = NewPrompter(strings.NewReader("n\ny\n"), &out)
+
+	summary, err := c.cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out.String(), "Delete app"); got != 2 {
+		t.Errorf("asked %d times, want 2: %q", got, out.String())
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "sha256:b" {
+		t.Errorf("deleted %v, want sha256:b", fake.deleted)
+	}
+	if summary.Deleted != 1 || summary.Retained != 1 {
+		t.Errorf("deleted %d and retained %d, want 1 and 1", summary.Deleted, summary.Retained)
+	}
+}
@@ -0,0 +1,58 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+// TaggingAPI is the subset of the Resource Groups Tagging API client used
+// to discover repositories by tag. It is satisfied by
+// *resourcegroupstaggingapi.ResourceGroupsTaggingAPI.
+type TaggingAPI interface {
+	GetResourcesPagesWithContext(aws.Context, *resourcegroupstaggingapi.GetResourcesInput, func(*resourcegroupstaggingapi.GetResourcesOutput, bool) bool, ...request.Option) error
+}
+
+// repositoryResourceType is the Resource Groups Tagging API type of ECR
+// repositories.
+const repositoryResourceType = "ecr:repository"
+
+// discoverRepositories returns the repositories carrying every tag in
+// Config.ResourceTags, as found by the Resource Groups Tagging API. Only
+// the name and ARN of each repository are set.
+func (c *Cleaner) discoverRepositories(ctx context.Context) ([]*ecr.Repository, error) {
+	input := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: aws.StringSlice([]string{repositoryResourceType}),
+	}
+	for key, value := range c.cfg.ResourceTags {
+		input.TagFilters = append(input.TagFilters, &resourcegroupstaggingapi.TagFilter{
+			Key:    aws.String(key),
+			Values: aws.StringSlice([]string{value}),
+		})
+	}
+
+	var repos []*ecr.Repository
+	var parseErr error
+	err := c.Tagging.GetResourcesPagesWithContext(ctx, input,
+		func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+			for _, resource := range page.ResourceTagMappingList {
+				arn := aws.StringValue(resource.ResourceARN)
+				_, name, ok := strings.Cut(arn, ":repository/")
+				if !ok {
+					parseErr = fmt.Errorf("unexpected repository ARN %q", arn)
+					return false
+				}
+				repos = append(repos, &ecr.Repository{RepositoryArn: aws.String(arn), RepositoryName: aws.String(name)})
+			}
+			return true
+		})
+	if err == nil {
+		err = parseErr
+	}
+	return repos, err
+}
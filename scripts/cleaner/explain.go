@@ -0,0 +1,56 @@
+package cleaner
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"scripts/logging"
+)
+
+// batchDeleteRequest is the body of a BatchDeleteImage request, as sent
+// on the wire.
+type batchDeleteRequest struct {
+	ImageIds       []imageID `json:"imageIds"`
+	RepositoryName string    `json:"repositoryName"`
+}
+
+// imageID is an image identifier in a batchDeleteRequest.
+type imageID struct {
+	ImageDigest string `json:"imageDigest,omitempty"`
+	ImageTag    string `json:"imageTag,omitempty"`
+}
+
+// explainDeletes logs the body of each BatchDeleteImage request a real run
+// would send for the repository, in the order it would send them: old
+// tagged images, then untagged ones, each with their manifest lists first,
+// then the tags removed in delete-by-tag mode. The bodies are encoded as
+// batchDeleteRequest, with the field names of the ECR JSON protocol.
+// Batches that a real run retries after transient failures are not shown.
+func (c *Cleaner) explainDeletes(repoName string, old, untagged, tags []*ecr.ImageIdentifier, manifestLists map[string]bool) {
+	var batches [][]*ecr.ImageIdentifier
+	for _, ids := range [][]*ecr.ImageIdentifier{old, untagged} {
+		lists, others := splitManifestLists(ids, manifestLists)
+		batches = append(batches, deleteBatches(lists)...)
+		batches = append(batches, deleteBatches(others)...)
+	}
+	batches = append(batches, deleteBatches(tags)...)
+
+	for i, batch := range batches {
+		request := batchDeleteRequest{RepositoryName: repoName}
+		for _, id := range batch {
+			request.ImageIds = append(request.ImageIds, imageID{
+				ImageDigest: aws.StringValue(id.ImageDigest),
+				ImageTag:    aws.StringValue(id.ImageTag),
+			})
+		}
+		body, err := json.Marshal(request)
+		if err != nil {
+			c.logRepo(logging.LevelError, repoName, "❌ Error encoding BatchDeleteImage request %d for %s: %v", i+1, repoName, err)
+			continue
+		}
+		c.logRepo(logging.LevelInfo, repoName, "🔎 BatchDeleteImage request %d/%d for %s (%d image IDs): %s",
+			i+1, len(batches), repoName, len(batch), body)
+	}
+}
@@ -0,0 +1,147 @@
+package cleaner
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// fakeECR is an in-memory ECRAPI holding the images of a single
+// repository. The repositories it lists all share those images.
+type fakeECR struct {
+	images []*ecr.ImageDetail
+	repos  []string
+	// fail holds, by digest, the failure codes returned by the next
+	// deletions of the image.
+	fail map[string][]string
+	// findings holds the severity of the scan findings of each digest.
+	findings map[string]string
+	// manifests holds the manifests BatchGetImage returns, by digest.
+	manifests map[string]string
+
+	// deleted records each image ID deleted: its digest, or tag:name for
+	// a deletion by tag. A deleted repository is recorded as
+	// repository:name.
+	deleted []string
+}
+
+var _ ECRAPI = (*fakeECR)(nil)
+
+// image returns an image with the digest and tags, pushed the given number
+// of days ago.
+func image(digest string, days int, tags ...string) *ecr.ImageDetail {
+	pushedAt := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	return &ecr.ImageDetail{
+		ImageDigest:      aws.String(digest),
+		ImagePushedAt:    &pushedAt,
+		ImageTags:        aws.StringSlice(tags),
+		ImageSizeInBytes: aws.Int64(1 << 20),
+	}
+}
+
+// DescribeRepositoriesPagesWithContext returns the repositories two to a
+// page.
+func (f *fakeECR) DescribeRepositoriesPagesWithContext(_ aws.Context, _ *ecr.DescribeRepositoriesInput, fn func(*ecr.DescribeRepositoriesOutput, bool) bool, _ ...request.Option) error {
+	var repos []*ecr.Repository
+	for _, name := range f.repos {
+		repos = append(repos, &ecr.Repository{RepositoryName: aws.String(name)})
+	}
+	if len(repos) == 0 {
+		fn(&ecr.DescribeRepositoriesOutput{}, true)
+	}
+	for i := 0; i < len(repos); i += 2 {
+		end := min(i+2, len(repos))
+		if !fn(&ecr.DescribeRepositoriesOutput{Repositories: repos[i:end]}, end == len(repos)) {
+			break
+		}
+	}
+	return nil
+}
+
+// DescribeImagesPagesWithContext returns the images two to a page.
+func (f *fakeECR) DescribeImagesPagesWithContext(_ aws.Context, _ *ecr.DescribeImagesInput, fn func(*ecr.DescribeImagesOutput, bool) bool, _ ...request.Option) error {
+	for i := 0; i < len(f.images); i += 2 {
+		end := min(i+2, len(f.images))
+		if !fn(&ecr.DescribeImagesOutput{ImageDetails: f.images[i:end]}, end == len(f.images)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeECR) BatchDeleteImageWithContext(_ aws.Context, in *ecr.BatchDeleteImageInput, _ ...request.Option) (*ecr.BatchDeleteImageOutput, error) {
+	out := &ecr.BatchDeleteImageOutput{}
+	for _, id := range in.ImageIds {
+		digest := aws.StringValue(id.ImageDigest)
+		if codes := f.fail[digest]; len(codes) > 0 {
+			f.fail[digest] = codes[1:]
+			out.Failures = append(out.Failures, &ecr.ImageFailure{ImageId: id, FailureCode: aws.String(codes[0]), FailureReason: aws.String("injected")})
+			continue
+		}
+		if id.ImageTag != nil && digest == "" {
+			f.deleted = append(f.deleted, "tag:"+*id.ImageTag)
+		} else {
+			f.deleted = append(f.deleted, digest)
+		}
+		out.ImageIds = append(out.ImageIds, id)
+	}
+	return out, nil
+}
+
+func (f *fakeECR) DeleteRepositoryWithContext(_ aws.Context, in *ecr.DeleteRepositoryInput, _ ...request.Option) (*ecr.DeleteRepositoryOutput, error) {
+	f.deleted = append(f.deleted, "repository:"+aws.StringValue(in.RepositoryName))
+	return &ecr.DeleteRepositoryOutput{}, nil
+}
+
+func (f *fakeECR) GetLifecyclePolicyWithContext(aws.Context, *ecr.GetLifecyclePolicyInput, ...request.Option) (*ecr.GetLifecyclePolicyOutput, error) {
+	return nil, awserr.New(ecr.ErrCodeLifecyclePolicyNotFoundException, "no lifecycle policy", nil)
+}
+
+func (f *fakeECR) ListTagsForResourceWithContext(aws.Context, *ecr.ListTagsForResourceInput, ...request.Option) (*ecr.ListTagsForResourceOutput, error) {
+	return &ecr.ListTagsForResourceOutput{}, nil
+}
+
+func (f *fakeECR) DescribeImageScanFindingsWithContext(_ aws.Context, in *ecr.DescribeImageScanFindingsInput, _ ...request.Option) (*ecr.DescribeImageScanFindingsOutput, error) {
+	severity, ok := f.findings[aws.StringValue(in.ImageId.ImageDigest)]
+	if !ok {
+		return nil, awserr.New(ecr.ErrCodeScanNotFoundException, "no scan", nil)
+	}
+	return &ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus:   &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusComplete)},
+		ImageScanFindings: &ecr.ImageScanFindings{FindingSeverityCounts: map[string]*int64{severity: aws.Int64(1)}},
+	}, nil
+}
+
+func (f *fakeECR) BatchGetImageWithContext(_ aws.Context, in *ecr.BatchGetImageInput, _ ...request.Option) (*ecr.BatchGetImageOutput, error) {
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range in.ImageIds {
+		if manifest, ok := f.manifests[aws.StringValue(id.ImageDigest)]; ok {
+			out.Images = append(out.Images, &ecr.Image{ImageId: id, ImageManifest: aws.String(manifest)})
+		}
+	}
+	return out, nil
+}
+
+// referrer returns an untagged OCI manifest referring to subject, and adds
+// its manifest to the fake.
+func (f *fakeECR) referrer(digest, subject string, days int) *ecr.ImageDetail {
+	if f.manifests == nil {
+		f.manifests = make(map[string]string)
+	}
+	f.manifests[digest] = `{"schemaVersion": 2, "subject": {"digest": "` + subject + `"}}`
+	referrer := image(digest, days)
+	referrer.ImageManifestMediaType = aws.String(mediaTypeOCIManifest)
+	return referrer
+}
+
+// reasons returns the reason for the decision on each image of the plan.
+func reasons(summary RepoSummary) map[string]string {
+	reasons := make(map[string]string)
+	for _, d := range summary.Plan {
+		reasons[d.Digest] = d.Reason
+	}
+	return reasons
+}
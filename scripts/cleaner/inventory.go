@@ -0,0 +1,215 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"scripts/logging"
+)
+
+// RepoListing is a repository in scope of a run, with its image count.
+type RepoListing struct {
+	Region     string `json:"region,omitempty"`
+	Repository string `json:"repository"`
+	Images     int    `json:"images"`
+}
+
+// RepoStats is an inventory of the images in one repository, or the
+// total over several. Tags counts the tags of all the images.
+type RepoStats struct {
+	Region     string     `json:"region,omitempty"`
+	Repository string     `json:"repository,omitempty"`
+	Images     int        `json:"images"`
+	Tagged     int        `json:"tagged"`
+	Untagged   int        `json:"untagged"`
+	Tags       int        `json:"tags"`
+	SizeBytes  int64      `json:"sizeBytes"`
+	OldestPush *time.Time `json:"oldestPush,omitempty"`
+	NewestPush *time.Time `json:"newestPush,omitempty"`
+}
+
+// Add accumulates other into s.
+func (s *RepoStats) Add(other RepoStats) {
+	s.Images += other.Images
+	s.Tagged += other.Tagged
+	s.Untagged += other.Untagged
+	s.Tags += other.Tags
+	s.SizeBytes += other.SizeBytes
+	if other.OldestPush != nil && (s.OldestPush == nil || other.OldestPush.Before(*s.OldestPush)) {
+		s.OldestPush = other.OldestPush
+	}
+	if other.NewestPush != nil && (s.NewestPush == nil || other.NewestPush.After(*s.NewestPush)) {
+		s.NewestPush = other.NewestPush
+	}
+}
+
+// ListRepositories returns the repositories a run would clean up, after
+// every repository filter, with the number of images in each. It neither
+// makes deletion decisions nor deletes anything.
+func (c *Cleaner) ListRepositories(ctx context.Context) ([]RepoListing, error) {
+	listings := []RepoListing{}
+	err := c.inventory(ctx, func(repoName string, images []*ecr.ImageDetail) {
+		listings = append(listings, RepoListing{Region: c.Region, Repository: repoName, Images: len(images)})
+	})
+	return listings, err
+}
+
+// Stats returns an inventory of the images in each repository a run would
+// clean up. Like ListRepositories, it changes nothing.
+func (c *Cleaner) Stats(ctx context.Context) ([]RepoStats, error) {
+	stats := []RepoStats{}
+	err := c.inventory(ctx, func(repoName string, images []*ecr.ImageDetail) {
+		repoStats := RepoStats{Region: c.Region, Repository: repoName, Images: len(images)}
+		for _, image := range images {
+			if len(image.ImageTags) > 0 {
+				repoStats.Tagged++
+			} else {
+				repoStats.Untagged++
+			}
+			repoStats.Tags += len(image.ImageTags)
+			repoStats.SizeBytes += aws.Int64Value(image.ImageSizeInBytes)
+			if pushedAt := image.ImagePushedAt; pushedAt != nil {
+				repoStats.Add(RepoStats{OldestPush: pushedAt, NewestPush: pushedAt})
+			}
+		}
+		stats = append(stats, repoStats)
+	})
+	return stats, err
+}
+
+// inventory calls fn with the images of each repository in scope, in
+// order. Repositories that do not exist are skipped.
+func (c *Cleaner) inventory(ctx context.Context, fn func(repoName string, images []*ecr.ImageDetail)) error {
+	repos, _, err := c.scope(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		inScope, err := c.inScope(ctx, repo)
+		if err != nil {
+			return err
+		}
+		if !inScope {
+			continue
+		}
+		repoName := aws.StringValue(repo.RepositoryName)
+		images, err := c.listImages(ctx, repoName)
+		if aerr := awserr.Error(nil); errors.As(err, &aerr) && aerr.Code() == ecr.ErrCodeRepositoryNotFoundException {
+			c.logRepo(logging.LevelWarn, repoName, "⏭️ Skipping %s: repository not found", repoName)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: failed to describe images: %w", repoName, err)
+		}
+		fn(repoName, images)
+	}
+	return nil
+}
+
+// scope lists the repositories in the region, or those named in
+// Config.Repositories, in name order and with the repository filters
+// applied. The protected repositories are left out and returned apart.
+func (c *Cleaner) scope(ctx context.Context) (repos []*ecr.Repository, protected []string, err error) {
+	// Only those carrying the resource tags are listed when the Tagging
+	// API is available
+	switch {
+	case len(c.cfg.Repositories) > 0:
+		c.Logger.Infof("Using the %d named repositories", len(c.cfg.Repositories))
+		for _, name := range c.cfg.Repositories {
+			repos = append(repos, &ecr.Repository{RepositoryName: aws.String(name)})
+		}
+	case c.discovers():
+		c.Logger.Infof("Discovering repositories by resource tag with the Resource Groups Tagging API")
+		repos, err = c.discoverRepositories(ctx)
+	default:
+		c.Logger.Infof("Listing repositories with DescribeRepositories")
+		repos, err = c.listRepositories(ctx)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(repos) == 0 {
+		c.Logger.Warnf("No repositories found in the specified region.")
+	}
+	if c.cfg.MinRepos > 0 && len(c.cfg.Repositories) == 0 {
+		c.Logger.Infof("Found %d repositories (-min-repos %d)", len(repos), c.cfg.MinRepos)
+		if len(repos) < c.cfg.MinRepos {
+			c.Logger.Warnf("⚠️ Found %d repositories, fewer than -min-repos %d; not cleaning up, check the account and region the credentials point at",
+				len(repos), c.cfg.MinRepos)
+			return nil, nil, fmt.Errorf("found %d repositories, fewer than -min-repos %d", len(repos), c.cfg.MinRepos)
+		}
+	}
+	// Sorted by name, so that the logs and plans of two runs can be diffed
+	sort.SliceStable(repos, func(i, j int) bool {
+		a, b := aws.StringValue(repos[i].RepositoryName), aws.StringValue(repos[j].RepositoryName)
+		if c.cfg.SortOrder == SortDesc {
+			return a > b
+		}
+		return a < b
+	})
+
+	if len(c.cfg.RepoFilter) > 0 || len(c.cfg.RepoExclude) > 0 {
+		total := len(repos)
+		repos = FilterRepositories(repos, c.cfg.RepoFilter, c.cfg.RepoExclude)
+		c.Logger.Infof("Repository filter matched %d of %d repositories (%d skipped)",
+			len(repos), total, total-len(repos))
+	}
+
+	if len(c.cfg.ProtectRepos) > 0 {
+		var unprotected []*ecr.Repository
+		for _, repo := range repos {
+			name := aws.StringValue(repo.RepositoryName)
+			if c.cfg.ProtectRepos[name] {
+				c.logRepo(logging.LevelInfo, name, "🛡️ Skipping protected repository %s", name)
+				protected = append(protected, name)
+				continue
+			}
+			unprotected = append(unprotected, repo)
+		}
+		repos = unprotected
+	}
+	return repos, protected, nil
+}
+
+// discovers reports whether repositories are discovered by resource tag
+// instead of being listed and checked one by one.
+func (c *Cleaner) discovers() bool {
+	return c.Tagging != nil && len(c.cfg.ResourceTags) > 0
+}
+
+// inScope applies the checks that need a call per repository: the
+// resource tags, unless the repositories were discovered by them, and
+// Config.SkipLifecycleManaged.
+func (c *Cleaner) inScope(ctx context.Context, repo *ecr.Repository) (bool, error) {
+	repoName := aws.StringValue(repo.RepositoryName)
+	if len(c.cfg.ResourceTags) > 0 && !c.discovers() {
+		matched, err := c.matchesResourceTags(ctx, repo)
+		if err != nil {
+			c.logRepo(logging.LevelWarn, repoName, "Failed to list resource tags for %s: %v", repoName, err)
+			return false, fmt.Errorf("failed to list resource tags: %w", err)
+		}
+		if !matched {
+			c.logRepo(logging.LevelInfo, repoName, "⏭️ Skipping %s: resource tags do not match the filter", repoName)
+			return false, nil
+		}
+	}
+	if c.cfg.SkipLifecycleManaged {
+		managed, err := c.hasLifecyclePolicy(ctx, repoName)
+		if err != nil {
+			c.logRepo(logging.LevelWarn, repoName, "Failed to get lifecycle policy for %s: %v", repoName, err)
+			return false, fmt.Errorf("failed to get lifecycle policy: %w", err)
+		}
+		if managed {
+			c.logRepo(logging.LevelInfo, repoName, "⏭️ Skipping %s: managed by an ECR lifecycle policy", repoName)
+			return false, nil
+		}
+	}
+	return true, nil
+}
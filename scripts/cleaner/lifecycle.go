@@ -0,0 +1,224 @@
+package cleaner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"scripts/logging"
+)
+
+// Supported values of a lifecycle rule's tagStatus and countType.
+const (
+	lifecycleTagged           = "tagged"
+	lifecycleUntagged         = "untagged"
+	lifecycleAny              = "any"
+	lifecycleCountMoreThan    = "imageCountMoreThan"
+	lifecycleSinceImagePushed = "sinceImagePushed"
+	lifecycleCountUnitDays    = "days"
+	lifecycleActionExpire     = "expire"
+)
+
+// LifecyclePolicy is an ECR lifecycle policy document, the JSON given to
+// `aws ecr put-lifecycle-policy`. Only the rules a comparison with the
+// plan needs are supported: a tagStatus with an optional tagPrefixList,
+// and an imageCountMoreThan count or a sinceImagePushed age in days.
+type LifecyclePolicy struct {
+	Rules []LifecycleRule `json:"rules"`
+}
+
+// LifecycleRule is one rule of a LifecyclePolicy.
+type LifecycleRule struct {
+	RulePriority int    `json:"rulePriority"`
+	Description  string `json:"description,omitempty"`
+	Selection    struct {
+		TagStatus      string   `json:"tagStatus"`
+		TagPrefixList  []string `json:"tagPrefixList,omitempty"`
+		TagPatternList []string `json:"tagPatternList,omitempty"`
+		CountType      string   `json:"countType"`
+		CountUnit      string   `json:"countUnit,omitempty"`
+		CountNumber    int      `json:"countNumber"`
+	} `json:"selection"`
+	Action struct {
+		Type string `json:"type"`
+	} `json:"action"`
+}
+
+// ParseLifecyclePolicy reads a lifecycle policy document, rejecting
+// unknown fields and the rules the evaluator does not support. The rules
+// are returned in priority order.
+func ParseLifecyclePolicy(data []byte) (*LifecyclePolicy, error) {
+	var policy LifecyclePolicy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, err
+	}
+	if len(policy.Rules) == 0 {
+		return nil, errors.New("the policy has no rules")
+	}
+	sort.SliceStable(policy.Rules, func(i, j int) bool {
+		return policy.Rules[i].RulePriority < policy.Rules[j].RulePriority
+	})
+	for i, rule := range policy.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", rule.RulePriority, err)
+		}
+		if i > 0 && rule.RulePriority == policy.Rules[i-1].RulePriority {
+			return nil, fmt.Errorf("rulePriority %d is used twice", rule.RulePriority)
+		}
+		// ECR only accepts a tagStatus of any on the last rule
+		if rule.Selection.TagStatus == lifecycleAny && i != len(policy.Rules)-1 {
+			return nil, fmt.Errorf("rule %d: tagStatus %q must have the highest rulePriority", rule.RulePriority, lifecycleAny)
+		}
+	}
+	return &policy, nil
+}
+
+// validate checks a rule against what ECR and the evaluator accept.
+func (r LifecycleRule) validate() error {
+	s := r.Selection
+	switch {
+	case r.RulePriority < 1:
+		return fmt.Errorf("rulePriority must be at least 1, got %d", r.RulePriority)
+	case len(s.TagPatternList) > 0:
+		return errors.New("tagPatternList is not supported")
+	case s.TagStatus != lifecycleTagged && s.TagStatus != lifecycleUntagged && s.TagStatus != lifecycleAny:
+		return fmt.Errorf("tagStatus must be %s, %s or %s, got %q", lifecycleTagged, lifecycleUntagged, lifecycleAny, s.TagStatus)
+	case s.TagStatus == lifecycleTagged && len(s.TagPrefixList) == 0:
+		return fmt.Errorf("tagStatus %s requires a tagPrefixList", lifecycleTagged)
+	case s.TagStatus != lifecycleTagged && len(s.TagPrefixList) > 0:
+		return fmt.Errorf("tagPrefixList requires tagStatus %s", lifecycleTagged)
+	case s.CountNumber < 1:
+		return fmt.Errorf("countNumber must be at least 1, got %d", s.CountNumber)
+	case r.Action.Type != lifecycleActionExpire:
+		return fmt.Errorf("action type must be %s, got %q", lifecycleActionExpire, r.Action.Type)
+	}
+	switch s.CountType {
+	case lifecycleCountMoreThan:
+		if s.CountUnit != "" {
+			return fmt.Errorf("countUnit is not allowed with %s", lifecycleCountMoreThan)
+		}
+	case lifecycleSinceImagePushed:
+		if s.CountUnit != lifecycleCountUnitDays {
+			return fmt.Errorf("countUnit must be %s with %s, got %q", lifecycleCountUnitDays, lifecycleSinceImagePushed, s.CountUnit)
+		}
+	default:
+		return fmt.Errorf("countType must be %s or %s, got %q", lifecycleCountMoreThan, lifecycleSinceImagePushed, s.CountType)
+	}
+	return nil
+}
+
+// selects reports whether the rule's selection matches the image. With a
+// tagPrefixList, each prefix must start one of the image's tags.
+func (r LifecycleRule) selects(image *ecr.ImageDetail) bool {
+	tags := aws.StringValueSlice(image.ImageTags)
+	switch r.Selection.TagStatus {
+	case lifecycleUntagged:
+		return len(tags) == 0
+	case lifecycleAny:
+		return true
+	}
+	if len(tags) == 0 {
+		return false
+	}
+	for _, prefix := range r.Selection.TagPrefixList {
+		matched := false
+		for _, tag := range tags {
+			if strings.HasPrefix(tag, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Expired returns the digests of the images the policy would expire at
+// now. As in ECR, the rules are applied in priority order and an image
+// selected by a rule is left alone by the rules after it, whether that
+// rule expires it or not. Images without a push time are never expired.
+func (p *LifecyclePolicy) Expired(images []*ecr.ImageDetail, now time.Time) map[string]bool {
+	expired := make(map[string]bool)
+	claimed := make(map[string]bool)
+	for _, rule := range p.Rules {
+		var selected []*ecr.ImageDetail
+		for _, image := range images {
+			digest := aws.StringValue(image.ImageDigest)
+			if image.ImagePushedAt == nil || claimed[digest] || !rule.selects(image) {
+				continue
+			}
+			claimed[digest] = true
+			selected = append(selected, image)
+		}
+
+		switch rule.Selection.CountType {
+		case lifecycleCountMoreThan:
+			sort.Slice(selected, func(i, j int) bool {
+				return newerFirst(*selected[i].ImagePushedAt, aws.StringValue(selected[i].ImageDigest),
+					*selected[j].ImagePushedAt, aws.StringValue(selected[j].ImageDigest))
+			})
+			for i := rule.Selection.CountNumber; i < len(selected); i++ {
+				expired[aws.StringValue(selected[i].ImageDigest)] = true
+			}
+		case lifecycleSinceImagePushed:
+			window := time.Duration(rule.Selection.CountNumber) * 24 * time.Hour
+			for _, image := range selected {
+				if now.Sub(*image.ImagePushedAt) > window {
+					expired[aws.StringValue(image.ImageDigest)] = true
+				}
+			}
+		}
+	}
+	return expired
+}
+
+// LifecycleComparison reconciles a repository's plan with the decisions
+// of the lifecycle policy in Config.CompareLifecycle.
+type LifecycleComparison struct {
+	// AgreedDeletes and AgreedKeeps count the images both delete or both
+	// keep.
+	AgreedDeletes int `json:"agreedDeletes"`
+	AgreedKeeps   int `json:"agreedKeeps"`
+	// ToolOnly lists the digests the plan deletes but the policy keeps,
+	// and PolicyOnly those the policy expires but the plan keeps.
+	ToolOnly   []string `json:"toolOnly,omitempty"`
+	PolicyOnly []string `json:"policyOnly,omitempty"`
+}
+
+// compareLifecycle evaluates Config.CompareLifecycle against the images of
+// a repository and logs each image on which it and the plan diverge.
+func (c *Cleaner) compareLifecycle(repoName string, images []*ecr.ImageDetail, plan []Decision) *LifecycleComparison {
+	expired := c.cfg.CompareLifecycle.Expired(images, time.Now())
+	comparison := &LifecycleComparison{}
+	for _, d := range plan {
+		deleted := d.Decision != decisionKeep
+		switch {
+		case deleted && expired[d.Digest]:
+			comparison.AgreedDeletes++
+		case !deleted && !expired[d.Digest]:
+			comparison.AgreedKeeps++
+		case deleted:
+			c.logImage(logging.LevelInfo, "", repoName, d.Digest, "⚖️ Only this tool deletes %s (%s); the lifecycle policy keeps it | Tags: %v",
+				d.Digest, d.Reason, d.Tags)
+			comparison.ToolOnly = append(comparison.ToolOnly, d.Digest)
+		default:
+			c.logImage(logging.LevelInfo, "", repoName, d.Digest, "⚖️ Only the lifecycle policy expires %s; this tool keeps it (%s) | Tags: %v",
+				d.Digest, d.Reason, d.Tags)
+			comparison.PolicyOnly = append(comparison.PolicyOnly, d.Digest)
+		}
+	}
+	c.logRepo(logging.LevelInfo, repoName, "⚖️ Lifecycle policy comparison for %s: %d agree, %d deleted only by this tool, %d expired only by the policy",
+		repoName, comparison.AgreedDeletes+comparison.AgreedKeeps, len(comparison.ToolOnly), len(comparison.PolicyOnly))
+	return comparison
+}
@@ -0,0 +1,150 @@
+package cleaner
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// testPolicy expires all but the newest prod image, untagged images after
+// a week and anything else after 30 days.
+const testPolicy = `{
+	"rules": [
+		{"rulePriority": 2, "selection": {"tagStatus": "untagged", "countType": "sinceImagePushed", "countUnit": "days", "countNumber": 7}, "action": {"type": "expire"}},
+		{"rulePriority": 1, "selection": {"tagStatus": "tagged", "tagPrefixList": ["prod-"], "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}},
+		{"rulePriority": 3, "selection": {"tagStatus": "any", "countType": "sinceImagePushed", "countUnit": "days", "countNumber": 30}, "action": {"type": "expire"}}
+	]
+}`
+
+func TestParseLifecyclePolicy(t *testing.T) {
+	policy, err := ParseLifecyclePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var priorities []int
+	for _, rule := range policy.Rules {
+		priorities = append(priorities, rule.RulePriority)
+	}
+	if !slices.Equal(priorities, []int{1, 2, 3}) {
+		t.Errorf("rules in order %v, want by priority", priorities)
+	}
+}
+
+func TestParseLifecyclePolicyRejects(t *testing.T) {
+	tests := []struct {
+		name, rules, want string
+	}{
+		{"no rules", ``, "no rules"},
+		{"tag patterns", `{"rulePriority": 1, "selection": {"tagStatus": "tagged", "tagPatternList": ["prod*"], "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}}`,
+			"tagPatternList is not supported"},
+		{"tagged without prefixes", `{"rulePriority": 1, "selection": {"tagStatus": "tagged", "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}}`,
+			"requires a tagPrefixList"},
+		{"days without a unit", `{"rulePriority": 1, "selection": {"tagStatus": "untagged", "countType": "sinceImagePushed", "countNumber": 1}, "action": {"type": "expire"}}`,
+			"countUnit must be days"},
+		{"any before another rule", `{"rulePriority": 1, "selection": {"tagStatus": "any", "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}},
+			{"rulePriority": 2, "selection": {"tagStatus": "untagged", "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}}`,
+			"must have the highest rulePriority"},
+		{"priority used twice", `{"rulePriority": 1, "selection": {"tagStatus": "untagged", "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}},
+			{"rulePriority": 1, "selection": {"tagStatus": "untagged", "countType": "imageCountMoreThan", "countNumber": 2}, "action": {"type": "expire"}}`,
+			"used twice"},
+		{"unknown field", `{"rulePriority": 1, "selection": {"tagStatus": "untagged", "countType": "imageCountMoreThan", "countNumber": 1}, "action": {"type": "expire"}, "extra": 1}`,
+			"unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLifecyclePolicy([]byte(`{"rules": [` + tt.rules + `]}`))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLifecyclePolicyExpired(t *testing.T) {
+	policy, err := ParseLifecyclePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []*ecr.ImageDetail{
+		image("sha256:prod-new", 60, "prod-2"),
+		image("sha256:prod-old", 70, "prod-1"),
+		image("sha256:untagged-new", 3),
+		image("sha256:untagged-old", 10),
+		image("sha256:dev-new", 20, "dev-2"),
+		image("sha256:dev-old", 40, "dev-1"),
+	}
+	var got []string
+	for digest := range policy.Expired(images, time.Now()) {
+		got = append(got, digest)
+	}
+	sort.Strings(got)
+	// The newest prod image is selected by the first rule, so the age
+	// rule after it leaves it alone
+	want := []string{"sha256:dev-old", "sha256:prod-old", "sha256:untagged-old"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expired %v, want %v", got, want)
+	}
+}
+
+func TestCompareLifecycle(t *testing.T) {
+	policy, err := ParseLifecyclePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:prod-new", 60, "prod-2"),
+		image("sha256:prod-old", 70, "prod-1"),
+		image("sha256:untagged-old", 10),
+		image("sha256:dev-old", 40, "dev-1"),
+	}}
+	cfg := Config{
+		Retention:         30,
+		Keep:              1,
+		Matchers:          []TagMatcher{PrefixMatcher("prod-")},
+		DeleteUntagged:    true,
+		UntaggedRetention: 30,
+		DryRun:            true,
+		CompareLifecycle:  policy,
+	}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := summary.Lifecycle
+	if got == nil {
+		t.Fatal("no comparison in the summary")
+	}
+	// Both delete the old prod image and keep the new one. Only the
+	// policy expires the untagged image, past its week, and the dev image
+	// that matches no prefix, which the plan keeps.
+	want := LifecycleComparison{
+		AgreedDeletes: 1,
+		AgreedKeeps:   1,
+		PolicyOnly:    []string{"sha256:untagged-old", "sha256:dev-old"},
+	}
+	if got.AgreedDeletes != want.AgreedDeletes || got.AgreedKeeps != want.AgreedKeeps ||
+		!slices.Equal(got.ToolOnly, want.ToolOnly) || !slices.Equal(got.PolicyOnly, want.PolicyOnly) {
+		t.Errorf("comparison = %+v, want %+v", *got, want)
+	}
+}
+
+func TestCompareLifecycleToolOnly(t *testing.T) {
+	policy, err := ParseLifecyclePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plan deletes untagged images at once; the policy waits a week
+	fake := &fakeECR{images: []*ecr.ImageDetail{image("sha256:untagged", 3)}}
+	summary, err := New(fake, Config{DeleteUntagged: true, DryRun: true, CompareLifecycle: policy}).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summary.Lifecycle; got == nil || !slices.Equal(got.ToolOnly, []string{"sha256:untagged"}) {
+		t.Errorf("comparison = %+v, want the untagged image deleted only by the tool", got)
+	}
+}
@@ -0,0 +1,68 @@
+package cleaner
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// Media types of multi-architecture images, whose manifest lists reference
+// one child manifest per platform.
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIImageIndex      = "application/vnd.oci.image.index.v1+json"
+)
+
+// isManifestList reports whether the image is a manifest list or OCI image
+// index rather than a single-platform image.
+func isManifestList(image *ecr.ImageDetail) bool {
+	for _, mediaType := range []*string{image.ImageManifestMediaType, image.ArtifactMediaType} {
+		switch aws.StringValue(mediaType) {
+		case mediaTypeDockerManifestList, mediaTypeOCIImageIndex:
+			return true
+		}
+	}
+	return false
+}
+
+// targetsMediaType reports whether the image's manifest or artifact media
+// type is one of Config.MediaTypes, or no media types are configured.
+func (c Config) targetsMediaType(image *ecr.ImageDetail) bool {
+	if len(c.MediaTypes) == 0 {
+		return true
+	}
+	return c.MediaTypes[aws.StringValue(image.ImageManifestMediaType)] || c.MediaTypes[aws.StringValue(image.ArtifactMediaType)]
+}
+
+// mediaType returns the artifact media type of the image, or its manifest
+// media type for plain container images.
+func mediaType(image *ecr.ImageDetail) string {
+	if image.ArtifactMediaType != nil {
+		return *image.ArtifactMediaType
+	}
+	return aws.StringValue(image.ImageManifestMediaType)
+}
+
+// splitManifestLists separates the manifest lists from the other
+// identifiers, keeping the order of each.
+func splitManifestLists(ids []*ecr.ImageIdentifier, manifestLists map[string]bool) (lists, others []*ecr.ImageIdentifier) {
+	for _, id := range ids {
+		if manifestLists[aws.StringValue(id.ImageDigest)] {
+			lists = append(lists, id)
+		} else {
+			others = append(others, id)
+		}
+	}
+	return lists, others
+}
+
+// deleteListsFirst deletes the manifest lists among the images before any
+// other image, so that batches deleted in parallel never race a parent
+// against the children it references.
+func (c *Cleaner) deleteListsFirst(ctx context.Context, repoName string, ids []*ecr.ImageIdentifier, manifestLists map[string]bool) deleteResult {
+	lists, others := splitManifestLists(ids, manifestLists)
+	result := c.deleteImages(ctx, repoName, lists)
+	result.add(c.deleteImages(ctx, repoName, others))
+	return result
+}
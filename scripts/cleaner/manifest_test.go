@@ -0,0 +1,83 @@
+package cleaner
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestSplitManifestLists(t *testing.T) {
+	ids := []*ecr.ImageIdentifier{
+		{ImageDigest: aws.String("sha256:child-1")},
+		{ImageDigest: aws.String("sha256:list")},
+		{ImageDigest: aws.String("sha256:child-2")},
+	}
+	digests := func(ids []*ecr.ImageIdentifier) []string {
+		var got []string
+		for _, id := range ids {
+			got = append(got, aws.StringValue(id.ImageDigest))
+		}
+		return got
+	}
+	lists, others := splitManifestLists(ids, map[string]bool{"sha256:list": true})
+	if got, want := digests(lists), []string{"sha256:list"}; !slices.Equal(got, want) {
+		t.Errorf("lists %v, want %v", got, want)
+	}
+	if got, want := digests(others), []string{"sha256:child-1", "sha256:child-2"}; !slices.Equal(got, want) {
+		t.Errorf("others %v, want %v", got, want)
+	}
+}
+
+func TestReferencedChildIsDeferred(t *testing.T) {
+	list := image("sha256:list", 60, "v1")
+	list.ImageManifestMediaType = aws.String(mediaTypeOCIImageIndex)
+	fake := &fakeECR{
+		images: []*ecr.ImageDetail{image("sha256:child", 60), list},
+		// Another manifest list, outside this run, still references the
+		// child
+		fail: map[string][]string{"sha256:child": {ecr.ImageFailureCodeImageReferencedByManifestList}},
+	}
+	cfg := Config{Retention: 30, Matchers: []TagMatcher{PrefixMatcher("v")}, DeleteUntagged: true}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fake.deleted, []string{"sha256:list"}) {
+		t.Errorf("deleted %v, want only the manifest list", fake.deleted)
+	}
+	if summary.Deferred != 1 || summary.Failed != 0 || summary.Retained != 1 {
+		t.Errorf("deferred %d, failed %d and retained %d; want 1, 0 and 1", summary.Deferred, summary.Failed, summary.Retained)
+	}
+}
+
+func TestMediaTypesKeepOtherArtifacts(t *testing.T) {
+	withMediaType := func(img *ecr.ImageDetail, manifest, artifact string) *ecr.ImageDetail {
+		img.ImageManifestMediaType = aws.String(manifest)
+		if artifact != "" {
+			img.ArtifactMediaType = aws.String(artifact)
+		}
+		return img
+	}
+	const helmConfig = "application/vnd.cncf.helm.config.v1+json"
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		withMediaType(image("sha256:docker", 60, "v1"), "application/vnd.docker.distribution.manifest.v2+json", ""),
+		withMediaType(image("sha256:chart", 60, "v2"), "application/vnd.oci.image.manifest.v1+json", helmConfig),
+	}}
+	cfg := Config{
+		Retention:        30,
+		IncludeUnmatched: true,
+		MediaTypes:       map[string]bool{"application/vnd.docker.distribution.manifest.v2+json": true},
+		DryRun:           true,
+	}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reasons(summary)
+	if got["sha256:chart"] != "media type not targeted" || got["sha256:docker"] != "older than retention" {
+		t.Errorf("reasons %v, want the chart kept for its media type and the image deleted", got)
+	}
+}
@@ -0,0 +1,111 @@
+package cleaner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// Decision records what the cleaner chose to do with one image and why.
+type Decision struct {
+	Digest    string   `json:"digest"`
+	Tags      []string `json:"tags,omitempty"`
+	AgeDays   int      `json:"ageDays"`
+	SizeBytes int64    `json:"sizeBytes,omitempty"`
+	Decision  string   `json:"decision"`
+	Reason    string   `json:"reason"`
+}
+
+// newDecision builds the Decision for an image, aged by agedFrom.
+func (c Config) newDecision(image *ecr.ImageDetail, decision, reason string) Decision {
+	d := Decision{
+		Digest:    aws.StringValue(image.ImageDigest),
+		Tags:      aws.StringValueSlice(image.ImageTags),
+		Decision:  decision,
+		Reason:    reason,
+		SizeBytes: aws.Int64Value(image.ImageSizeInBytes),
+	}
+	if image.ImagePushedAt != nil {
+		d.AgeDays = int(time.Since(c.agedFrom(image)).Hours() / 24)
+	}
+	return d
+}
+
+// formatPlan renders a repository's decisions as a KEEP section followed
+// by a DELETE section.
+func formatPlan(repoName string, plan []Decision) string {
+	var keep, del []Decision
+	for _, d := range plan {
+		if d.Decision == decisionKeep {
+			keep = append(keep, d)
+		} else {
+			del = append(del, d)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📋 Plan for %s", repoName)
+	fmt.Fprintf(&b, "\n  KEEP (%d)", len(keep))
+	for _, d := range keep {
+		fmt.Fprintf(&b, "\n    %s %v (%s)", d.Digest, d.Tags, d.Reason)
+	}
+	fmt.Fprintf(&b, "\n  DELETE (%d)", len(del))
+	for _, d := range del {
+		fmt.Fprintf(&b, "\n    %s %v age %d days (%s)", d.Digest, d.Tags, d.AgeDays, d.Reason)
+	}
+	return b.String()
+}
+
+// Candidate is an image a dry run would delete.
+type Candidate struct {
+	Region     string
+	Repository string
+	Digest     string
+	Untagged   bool
+	AgeDays    int
+	SizeBytes  int64
+}
+
+// CandidateKey identifies an image across regions and repositories, as
+// used by Config.DeleteOnly.
+func CandidateKey(region, repoName, digest string) string {
+	return region + "/" + repoName + "@" + digest
+}
+
+// Key returns the CandidateKey of the candidate.
+func (c Candidate) Key() string {
+	return CandidateKey(c.Region, c.Repository, c.Digest)
+}
+
+// Candidates returns the images the plans in s would delete: the untagged
+// images first and then the tagged ones, each from the oldest. A cap on
+// deletions that takes them in this order keeps the most valuable images.
+func (s RunSummary) Candidates() []Candidate {
+	var candidates []Candidate
+	for _, repo := range s.Repositories {
+		for _, d := range repo.Plan {
+			if d.Decision == decisionKeep {
+				continue
+			}
+			candidates = append(candidates, Candidate{
+				Region:     repo.Region,
+				Repository: repo.Repository,
+				Digest:     d.Digest,
+				Untagged:   len(d.Tags) == 0,
+				AgeDays:    d.AgeDays,
+				SizeBytes:  d.SizeBytes,
+			})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Untagged != candidates[j].Untagged {
+			return candidates[i].Untagged
+		}
+		return candidates[i].AgeDays > candidates[j].AgeDays
+	})
+	return candidates
+}
@@ -0,0 +1,61 @@
+package cleaner
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestCandidatesUntaggedFirst(t *testing.T) {
+	summary := RunSummary{Repositories: []RepoSummary{
+		{Region: "eu-west-1", Repository: "app", Plan: []Decision{
+			{Digest: "sha256:tagged-old", Tags: []string{"v1"}, AgeDays: 90, Decision: decisionDryRun},
+			{Digest: "sha256:kept", Tags: []string{"v3"}, AgeDays: 1, Decision: decisionKeep},
+			{Digest: "sha256:untagged-new", AgeDays: 10, Decision: decisionDryRun},
+		}},
+		{Region: "eu-west-1", Repository: "web", Plan: []Decision{
+			{Digest: "sha256:tagged-new", Tags: []string{"v2"}, AgeDays: 40, Decision: decisionDryRun},
+			{Digest: "sha256:untagged-old", AgeDays: 50, Decision: decisionDryRun},
+		}},
+	}}
+	var got []string
+	for _, candidate := range summary.Candidates() {
+		got = append(got, candidate.Digest)
+	}
+	want := []string{"sha256:untagged-old", "sha256:untagged-new", "sha256:tagged-old", "sha256:tagged-new"}
+	if !slices.Equal(got, want) {
+		t.Errorf("candidates %v, want %v", got, want)
+	}
+	if key := summary.Candidates()[0].Key(); key != "eu-west-1/web@sha256:untagged-old" {
+		t.Errorf("Key() = %q", key)
+	}
+}
+
+func TestDeleteOnlyKeepsTheRest(t *testing.T) {
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:untagged", 50),
+		image("sha256:tagged", 60, "v1"),
+		image("sha256:pinned", 70, "v0", "stable"),
+	}}
+	cfg := Config{
+		Retention:      30,
+		Matchers:       []TagMatcher{PrefixMatcher("v")},
+		DeleteUntagged: true,
+		ProtectTags:    map[string]bool{"stable": true},
+		DeleteByTag:    true,
+		DeleteOnly:     map[string]bool{CandidateKey("", "app", "sha256:untagged"): true},
+	}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tags of a protected image are not removed beyond the cap either
+	if want := []string{"sha256:untagged"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted %v, want %v", fake.deleted, want)
+	}
+	if summary.TagsDeleted != 0 || summary.Retained != 2 {
+		t.Errorf("deleted %d tags and retained %d images, want 0 and 2", summary.TagsDeleted, summary.Retained)
+	}
+}
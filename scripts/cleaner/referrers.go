@@ -0,0 +1,86 @@
+package cleaner
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// mediaTypeOCIManifest is the media type of single OCI manifests, which
+// referrers such as signatures and SBOMs are stored as.
+const mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+
+// maxBatchGetSize is the maximum number of image IDs BatchGetImage accepts
+// in a single call.
+const maxBatchGetSize = 100
+
+// mayRefer reports whether the image can be a referrer: an untagged OCI
+// manifest or image index, which is how the referrers API stores them.
+func mayRefer(image *ecr.ImageDetail) bool {
+	if len(image.ImageTags) > 0 {
+		return false
+	}
+	switch aws.StringValue(image.ImageManifestMediaType) {
+	case mediaTypeOCIManifest, mediaTypeOCIImageIndex:
+		return true
+	}
+	return false
+}
+
+// referrerSubjects returns the subject digest of each referrer among the
+// images whose subject is in the repository. The manifests of the images
+// that may be referrers are fetched with BatchGetImage, up to 100 per call.
+func (c *Cleaner) referrerSubjects(ctx context.Context, repoName string, images []*ecr.ImageDetail) (map[string]string, error) {
+	present := make(map[string]bool)
+	var ids []*ecr.ImageIdentifier
+	for _, image := range images {
+		present[aws.StringValue(image.ImageDigest)] = true
+		if mayRefer(image) {
+			ids = append(ids, &ecr.ImageIdentifier{ImageDigest: image.ImageDigest})
+		}
+	}
+
+	subjects := make(map[string]string)
+	for start := 0; start < len(ids); start += maxBatchGetSize {
+		out, err := c.svc.BatchGetImageWithContext(ctx, &ecr.BatchGetImageInput{
+			RepositoryName:     aws.String(repoName),
+			ImageIds:           ids[start:min(start+maxBatchGetSize, len(ids))],
+			AcceptedMediaTypes: aws.StringSlice([]string{mediaTypeOCIManifest, mediaTypeOCIImageIndex}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range out.Images {
+			var manifest struct {
+				Subject *struct {
+					Digest string `json:"digest"`
+				} `json:"subject"`
+			}
+			if err := json.Unmarshal([]byte(aws.StringValue(image.ImageManifest)), &manifest); err != nil {
+				continue
+			}
+			// A referrer whose subject is gone follows the usual rules
+			if manifest.Subject != nil && present[manifest.Subject.Digest] {
+				subjects[aws.StringValue(image.ImageId.ImageDigest)] = manifest.Subject.Digest
+			}
+		}
+	}
+	return subjects, nil
+}
+
+// subjectDeleted reports whether the subject of the referrer is deleted,
+// following referrers of referrers, such as the signature of an SBOM, to
+// the image they are attached to.
+func subjectDeleted(digest string, subjects map[string]string, deleting map[string]bool) bool {
+	for range len(subjects) {
+		subject := subjects[digest]
+		if _, ok := subjects[subject]; !ok {
+			return deleting[subject]
+		}
+		digest = subject
+	}
+	// A cycle of referrers has no image to follow
+	return false
+}
@@ -0,0 +1,113 @@
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// referrerConfig deletes the v images after 30 days, with their
+// referrers.
+func referrerConfig() Config {
+	return Config{
+		Retention:       30,
+		Matchers:        []TagMatcher{PrefixMatcher("v")},
+		DeleteUntagged:  true,
+		FollowReferrers: true,
+		DryRun:          true,
+	}
+}
+
+func TestReferrersFollowTheirSubject(t *testing.T) {
+	fake := &fakeECR{}
+	fake.images = []*ecr.ImageDetail{
+		image("sha256:old", 60, "v1"),
+		fake.referrer("sha256:old-sig", "sha256:old", 60),
+		fake.referrer("sha256:old-sig-sig", "sha256:old-sig", 60),
+		image("sha256:new", 1, "v2"),
+		fake.referrer("sha256:new-sig", "sha256:new", 60),
+	}
+	summary, err := New(fake, referrerConfig()).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"sha256:old":         "older than retention",
+		"sha256:old-sig":     "subject deleted",
+		"sha256:old-sig-sig": "subject deleted",
+		"sha256:new":         "within retention",
+		"sha256:new-sig":     "subject retained",
+	}
+	if got := reasons(summary); !maps.Equal(got, want) {
+		t.Errorf("reasons %v, want %v", got, want)
+	}
+}
+
+func TestReferrersKeptByTheUntaggedRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*Config)
+		// sigAge is the age in days of the referrer.
+		sigAge int
+		want   map[string]string
+	}{
+		{
+			name:   "min-keep goes to the subject",
+			config: func(cfg *Config) { cfg.MinKeep = 1 },
+			sigAge: 59,
+			want:   map[string]string{"sha256:old": "minimum keep floor", "sha256:old-sig": "subject retained"},
+		},
+		{
+			name:   "untagged deletion disabled",
+			config: func(cfg *Config) { cfg.DeleteUntagged = false },
+			sigAge: 60,
+			want:   map[string]string{"sha256:old": "older than retention", "sha256:old-sig": "untagged deletion disabled"},
+		},
+		{
+			name:   "grace period",
+			config: func(cfg *Config) { cfg.GracePeriod = 48 * time.Hour },
+			sigAge: 1,
+			want:   map[string]string{"sha256:old": "older than retention", "sha256:old-sig": "within grace period"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeECR{}
+			fake.images = []*ecr.ImageDetail{image("sha256:old", 60, "v1"), fake.referrer("sha256:old-sig", "sha256:old", tt.sigAge)}
+			cfg := referrerConfig()
+			tt.config(&cfg)
+			summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reasons(summary); !maps.Equal(got, tt.want) {
+				t.Errorf("reasons %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferrerDeletionsAreConfirmed(t *testing.T) {
+	fake := &fakeECR{}
+	fake.images = []*ecr.ImageDetail{image("sha256:old", 60, "v1"), fake.referrer("sha256:old-sig", "sha256:old", 60)}
+	cfg := referrerConfig()
+	cfg.DryRun = false
+	var out bytes.Buffer
+	c := New(fake, cfg)
+	c.Prompter = NewPrompter(strings.NewReader("y\nn\n"), &out)
+
+	if _, err := c.cleanRepository(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "sha256:old-sig (referrer of sha256:old)") {
+		t.Errorf("the referrer was not asked for: %q", out.String())
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "sha256:old" {
+		t.Errorf("deleted %v, want only sha256:old", fake.deleted)
+	}
+}
@@ -0,0 +1,88 @@
+package cleaner
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// Decisions recorded in the CSV report.
+const (
+	decisionKeep   = "keep"
+	decisionDelete = "delete"
+	decisionDryRun = "dry-run"
+)
+
+// ReportWriter writes a CSV audit report with one row per image
+// considered. It is safe for concurrent use, and a nil *ReportWriter
+// discards all rows.
+type ReportWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+	closed bool
+}
+
+// NewReportWriter creates the report file and writes the header row.
+func NewReportWriter(path string) (*ReportWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &ReportWriter{file: file, writer: csv.NewWriter(file)}
+	r.writer.Write([]string{"region", "repository", "digest", "tags", "pushed_at", "age_days", "decision", "reason"})
+	return r, nil
+}
+
+// record writes the decision made for a single image.
+func (r *ReportWriter) record(region, repoName string, image *ecr.ImageDetail, decision, reason string) {
+	if r == nil {
+		return
+	}
+
+	var pushedAt, ageDays string
+	if image.ImagePushedAt != nil {
+		pushedAt = image.ImagePushedAt.UTC().Format(time.RFC3339)
+		ageDays = strconv.Itoa(int(time.Since(*image.ImagePushedAt).Hours() / 24))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.writer.Write([]string{
+		region,
+		repoName,
+		aws.StringValue(image.ImageDigest),
+		strings.Join(aws.StringValueSlice(image.ImageTags), " "),
+		pushedAt,
+		ageDays,
+		decision,
+		reason,
+	})
+}
+
+// Close flushes the buffered rows and closes the file. It may be called
+// more than once; later calls do nothing.
+func (r *ReportWriter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,178 @@
+package cleaner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// Rule is a retention rule for the images whose tags match Prefix. Each
+// rule keeps its KeepCount most recent images and deletes the rest once
+// they are older than MaxAgeDays. When these are left out, the rule uses
+// Config.Keep and Config.Retention. AlwaysKeep keeps every matching image.
+type Rule struct {
+	Prefix     string `json:"prefix,omitempty"`
+	KeepCount  *int   `json:"keepCount,omitempty"`
+	MaxAgeDays *int   `json:"maxAgeDays,omitempty"`
+	AlwaysKeep bool   `json:"alwaysKeep,omitempty"`
+}
+
+// Policy is the content of a policy file: the per-prefix rules and the
+// default rule for tagged images that match none of them.
+type Policy struct {
+	Rules   []Rule `json:"rules"`
+	Default *Rule  `json:"default,omitempty"`
+}
+
+// LoadPolicy reads and validates a JSON policy file, rejecting unknown
+// fields.
+func LoadPolicy(name string) (Policy, error) {
+	var policy Policy
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return policy, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return policy, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := policy.validate(); err != nil {
+		return policy, fmt.Errorf("%s: %w", name, err)
+	}
+	return policy, nil
+}
+
+// validate checks each rule, and that every rule but the default has a
+// prefix of its own.
+func (p Policy) validate() error {
+	seen := make(map[string]bool)
+	for i, rule := range p.Rules {
+		if rule.Prefix == "" {
+			return fmt.Errorf("rule %d: prefix must not be empty", i+1)
+		}
+		if seen[rule.Prefix] {
+			return fmt.Errorf("rule %d: duplicate prefix %q", i+1, rule.Prefix)
+		}
+		seen[rule.Prefix] = true
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d (%s): %w", i+1, rule.Prefix, err)
+		}
+	}
+	if p.Default != nil {
+		if p.Default.Prefix != "" {
+			return errors.New("default rule: must not have a prefix")
+		}
+		if err := p.Default.validate(); err != nil {
+			return fmt.Errorf("default rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// validate checks the counts of a single rule.
+func (r Rule) validate() error {
+	switch {
+	case r.KeepCount != nil && *r.KeepCount < 0:
+		return errors.New("keepCount must not be negative")
+	case r.MaxAgeDays != nil && *r.MaxAgeDays < 0:
+		return errors.New("maxAgeDays must not be negative")
+	case r.AlwaysKeep && (r.KeepCount != nil || r.MaxAgeDays != nil):
+		return errors.New("alwaysKeep cannot be combined with keepCount or maxAgeDays")
+	}
+	return nil
+}
+
+// rulesFor returns the rules for the images matched by patterns, which is
+// the default rule when they matched no pattern.
+func (c Config) rulesFor(patterns []string) []Rule {
+	if len(patterns) == 0 {
+		if c.DefaultRule == nil {
+			return nil
+		}
+		return []Rule{*c.DefaultRule}
+	}
+	var rules []Rule
+	for _, pattern := range patterns {
+		if rule, ok := c.Rules[pattern]; ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// alwaysKept reports whether an always-keep rule applies to the images
+// matched by patterns.
+func (c Config) alwaysKept(patterns []string) bool {
+	for _, rule := range c.rulesFor(patterns) {
+		if rule.AlwaysKeep {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredUnder reports whether an image matched by patterns is expired.
+// An image matched by several patterns must be past the retention of each
+// of them, so the longest wins. The image is aged by agedFrom, and the
+// last pull of a pulled image overrides them all with UnpulledDays.
+func (c Config) expiredUnder(image *ecr.ImageDetail, patterns []string) bool {
+	if expired, ok := c.pullExpired(image); ok {
+		return expired
+	}
+	pushedAt := c.agedFrom(image)
+	if len(patterns) == 0 {
+		if c.DefaultRule != nil {
+			return c.isExpiredAfter(pushedAt, c.DefaultRule.retention(c.retention()))
+		}
+		return c.isExpired(pushedAt)
+	}
+	for _, pattern := range patterns {
+		retention := c.retention()
+		if rule, ok := c.Rules[pattern]; ok {
+			retention = rule.retention(c.retention())
+		}
+		if !c.isExpiredAfter(pushedAt, retention) {
+			return false
+		}
+	}
+	return true
+}
+
+// expiryUnder returns the earliest time at which expiredUnder may start to
+// hold for the image. With several patterns it takes the shortest
+// retention, which is never later than the actual expiry; Before only
+// ever delays it.
+func (c Config) expiryUnder(image *ecr.ImageDetail, patterns []string) time.Time {
+	if _, ok := c.pullExpired(image); ok {
+		return expiresAfter(*image.LastRecordedPullTime, c.UnpulledDays)
+	}
+	retention := c.retention()
+	if len(patterns) == 0 && c.DefaultRule != nil {
+		retention = c.DefaultRule.retention(c.retention())
+	}
+	for i, pattern := range patterns {
+		patternRetention := c.retention()
+		if rule, ok := c.Rules[pattern]; ok {
+			patternRetention = rule.retention(c.retention())
+		}
+		if i == 0 || patternRetention < retention {
+			retention = patternRetention
+		}
+	}
+	return c.agedFrom(image).Add(retention)
+}
+
+// retention returns the rule's retention window, from its maximum age, or
+// fallback if it has none.
+func (r Rule) retention(fallback time.Duration) time.Duration {
+	if r.MaxAgeDays != nil {
+		return daysWindow(*r.MaxAgeDays)
+	}
+	return fallback
+}
@@ -0,0 +1,111 @@
+package cleaner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// writePolicy writes a policy file and returns its name.
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadPolicyRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown field", `{"rules": [{"prefix": "prod-", "keep": 1}]}`, "unknown field"},
+		{"empty prefix", `{"rules": [{"keepCount": 1}]}`, "prefix must not be empty"},
+		{"duplicate prefix", `{"rules": [{"prefix": "a"}, {"prefix": "a"}]}`, "duplicate prefix"},
+		{"negative count", `{"rules": [{"prefix": "a", "keepCount": -1}]}`, "keepCount must not be negative"},
+		{"always keep with a count", `{"rules": [{"prefix": "a", "alwaysKeep": true, "maxAgeDays": 3}]}`, "alwaysKeep cannot be combined"},
+		{"default with a prefix", `{"rules": [], "default": {"prefix": "a"}}`, "must not have a prefix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadPolicy(writePolicy(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, `{
+		"rules": [
+			{"prefix": "prod-", "keepCount": 2, "maxAgeDays": 90},
+			{"prefix": "dev-", "keepCount": 0, "maxAgeDays": 7},
+			{"prefix": "base-", "alwaysKeep": true}
+		],
+		"default": {"keepCount": 0, "maxAgeDays": 14}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Retention: 30, Keep: 1, Rules: make(map[string]Rule), DefaultRule: policy.Default, DryRun: true}
+	for _, rule := range policy.Rules {
+		cfg.Rules[rule.Prefix] = rule
+		cfg.Matchers = append(cfg.Matchers, PrefixMatcher(rule.Prefix))
+	}
+	fake := &fakeECR{images: []*ecr.ImageDetail{
+		image("sha256:prod-new", 100, "prod-3"), image("sha256:prod-mid", 110, "prod-2"), image("sha256:prod-old", 120, "prod-1"),
+		image("sha256:prod-young", 60, "prod-0"),
+		image("sha256:dev", 10, "dev-1"), image("sha256:dev-new", 3, "dev-2"),
+		image("sha256:base", 400, "base-1"),
+		image("sha256:other", 20, "other"), image("sha256:other-new", 10, "other-2"),
+	}}
+	summary, err := New(fake, cfg).cleanRepository(context.Background(), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"sha256:prod-young": "latest tag-match",
+		"sha256:prod-new":   "latest tag-match",
+		"sha256:prod-mid":   "older than retention",
+		"sha256:prod-old":   "older than retention",
+		"sha256:dev":        "older than retention",
+		"sha256:dev-new":    "within retention",
+		"sha256:base":       "always-keep rule",
+		"sha256:other":      "older than retention",
+		"sha256:other-new":  "within retention",
+	}
+	got := reasons(summary)
+	for digest, reason := range want {
+		if got[digest] != reason {
+			t.Errorf("%s: reason %q, want %q", digest, got[digest], reason)
+		}
+	}
+}
+
+func TestPolicyLongestRetentionWins(t *testing.T) {
+	ptr := func(n int) *int { return &n }
+	cfg := Config{
+		Retention: 30,
+		Matchers:  []TagMatcher{PrefixMatcher("prod-"), PrefixMatcher("dev-")},
+		Rules: map[string]Rule{
+			"prod-": {KeepCount: ptr(0), MaxAgeDays: ptr(90)},
+			"dev-":  {KeepCount: ptr(0), MaxAgeDays: ptr(7)},
+		},
+	}
+	// Expired under the dev- rule, but not under the prod- one
+	fake := &fakeECR{images: []*ecr.ImageDetail{image("sha256:both", 60, "prod-1", "dev-1")}}
+	if _, err := New(fake, cfg).cleanRepository(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.deleted) != 0 {
+		t.Errorf("deleted %v, want the image kept by the longer retention", fake.deleted)
+	}
+}
@@ -0,0 +1,71 @@
+package cleaner
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+
+	"scripts/logging"
+)
+
+// severityRank orders the ECR finding severities from least to most
+// severe.
+var severityRank = map[string]int{
+	ecr.FindingSeverityUndefined:     0,
+	ecr.FindingSeverityInformational: 1,
+	ecr.FindingSeverityLow:           2,
+	ecr.FindingSeverityMedium:        3,
+	ecr.FindingSeverityHigh:          4,
+	ecr.FindingSeverityCritical:      5,
+}
+
+// hasScanFindings reports whether the image's latest scan found any
+// vulnerability at or above Config.MinSeverity. Images that were never
+// scanned, or whose scan did not complete, have no findings.
+func (c *Cleaner) hasScanFindings(ctx context.Context, repoName string, image *ecr.ImageDetail) (bool, error) {
+	out, err := c.svc.DescribeImageScanFindingsWithContext(ctx, &ecr.DescribeImageScanFindingsInput{
+		RepositoryName: aws.String(repoName),
+		ImageId:        &ecr.ImageIdentifier{ImageDigest: image.ImageDigest},
+		MaxResults:     aws.Int64(1),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeScanNotFoundException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if out.ImageScanStatus == nil || aws.StringValue(out.ImageScanStatus.Status) != ecr.ScanStatusComplete ||
+		out.ImageScanFindings == nil {
+		return false, nil
+	}
+
+	minRank := severityRank[c.cfg.MinSeverity]
+	for severity, count := range out.ImageScanFindings.FindingSeverityCounts {
+		if rank, ok := severityRank[severity]; ok && rank >= minRank && aws.Int64Value(count) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// quarantined reports whether a deletion candidate must be kept because of
+// its scan findings. It always returns false unless Config.MinSeverity is
+// set. An image whose findings cannot be read is kept.
+func (c *Cleaner) quarantined(ctx context.Context, repoName string, image *ecr.ImageDetail) bool {
+	if c.cfg.MinSeverity == "" {
+		return false
+	}
+	digest := aws.StringValue(image.ImageDigest)
+	found, err := c.hasScanFindings(ctx, repoName, image)
+	if err != nil {
+		c.logImage(logging.LevelWarn, actionKeep, repoName, digest, "Keeping %s: failed to read scan findings: %v", digest, err)
+		return true
+	}
+	if found {
+		c.logImage(logging.LevelDebug, actionKeep, repoName, digest, "✅ Image retained (%s+ scan findings): %s | Tags: %v",
+			c.cfg.MinSeverity, digest, aws.StringValueSlice(image.ImageTags))
+	}
+	return found
+}
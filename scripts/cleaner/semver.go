@@ -0,0 +1,127 @@
+package cleaner
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"golang.org/x/mod/semver"
+)
+
+// Supported values for Config.KeepBy.
+const (
+	KeepByPushed  = "pushed"
+	KeepBySemver  = "semver"
+	KeepByVersion = "version"
+)
+
+// versionPattern finds a MAJOR.MINOR.PATCH version, with an optional
+// pre-release suffix, anywhere in a tag such as v1.2.3 or release-1.2.3-rc.1.
+var versionPattern = regexp.MustCompile(`\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?`)
+
+// tagVersion returns the semantic version in tag in the canonical "v"
+// form, or "" if the tag carries none.
+func tagVersion(tag string) string {
+	version := "v" + versionPattern.FindString(tag)
+	if !semver.IsValid(version) {
+		return ""
+	}
+	return version
+}
+
+// highestVersion returns the highest semantic version among the tags, or
+// "" if none of them carries one.
+func highestVersion(tags []*string) string {
+	var highest string
+	for _, tag := range tags {
+		if tag == nil {
+			continue
+		}
+		if version := tagVersion(*tag); version != "" && (highest == "" || semver.Compare(version, highest) > 0) {
+			highest = version
+		}
+	}
+	return highest
+}
+
+// higherVersionFirst orders images by descending semantic version. Images
+// without a version sort after the versioned ones, newest first.
+func higherVersionFirst(a, b taggedImage) bool {
+	switch {
+	case a.version != "" && b.version == "":
+		return true
+	case a.version == "" && b.version != "":
+		return false
+	case a.version != b.version:
+		return semver.Compare(a.version, b.version) > 0
+	}
+	return newerFirst(a.pushedTime, a.digest, b.pushedTime, b.digest)
+}
+
+// versionOf returns the version that groups the image with the given tags
+// when keeping by version: the first capture group of VersionPattern, or
+// its whole match, in the highest such tag, or without VersionPattern the
+// highest semantic version. It is "" if no tag carries a version.
+func (c Config) versionOf(tags []*string) string {
+	if c.VersionPattern == nil {
+		return highestVersion(tags)
+	}
+	var highest string
+	for _, tag := range aws.StringValueSlice(tags) {
+		match := c.VersionPattern.FindStringSubmatch(tag)
+		if match == nil {
+			continue
+		}
+		version := match[0]
+		if len(match) > 1 {
+			version = match[1]
+		}
+		if highest == "" || compareVersions(version, highest) > 0 {
+			highest = version
+		}
+	}
+	return highest
+}
+
+// compareVersions orders two versions extracted by VersionPattern.
+// Versions that read as semantic versions, with or without a leading v,
+// are compared as such, so 1.10.0 is above 1.9.0, and rank above those
+// that do not; the rest are compared as strings.
+func compareVersions(a, b string) int {
+	semverA, semverB := "v"+strings.TrimPrefix(a, "v"), "v"+strings.TrimPrefix(b, "v")
+	validA, validB := semver.IsValid(semverA), semver.IsValid(semverB)
+	switch {
+	case validA && validB:
+		if c := semver.Compare(semverA, semverB); c != 0 {
+			return c
+		}
+	case validA:
+		return 1
+	case validB:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// versionGroups groups images, sorted newest first, by their version. The
+// groups are ordered by their newest image, and an image without a version
+// is a group of its own.
+func (c Config) versionGroups(images []taggedImage) [][]taggedImage {
+	var groups [][]taggedImage
+	index := make(map[string]int)
+	for _, image := range images {
+		version := c.versionOf(image.tags)
+		if version == "" {
+			groups = append(groups, []taggedImage{image})
+			continue
+		}
+		if i, ok := index[version]; ok {
+			groups[i] = append(groups[i], image)
+			continue
+		}
+		index[version] = len(groups)
+		groups = append(groups, []taggedImage{image})
+	}
+	return groups
+}
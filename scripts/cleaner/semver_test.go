@@ -0,0 +1,131 @@
+package cleaner
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+func TestTagVersion(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"v1.2.3", "v1.2.3"},
+		{"1.2.3", "v1.2.3"},
+		{"release-1.10.0-rc.1", "v1.10.0-rc.1"},
+		{"app-2.0.1-amd64", "v2.0.1-amd64"},
+		{"1.2", ""},
+		{"latest", ""},
+	}
+	for _, tt := range tests {
+		if got := tagVersion(tt.tag); got != tt.want {
+			t.Errorf("tagVersion(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestHighestVersion(t *testing.T) {
+	tags := aws.StringSlice([]string{"release-1.9.0", "release-1.10.0-rc.1", "release-1.10.0", "latest"})
+	if got := highestVersion(tags); got != "v1.10.0" {
+		t.Errorf("highestVersion = %q, want v1.10.0", got)
+	}
+	if got := highestVersion(aws.StringSlice([]string{"latest", "nightly"})); got != "" {
+		t.Errorf("highestVersion without versions = %q, want none", got)
+	}
+}
+
+func TestKeepBySemverKeepsTheHighestVersion(t *testing.T) {
+	// A hotfix to an older line is pushed after the newest release
+	images := []*ecr.ImageDetail{
+		image("sha256:v1100", 60, "app-1.10.0"),
+		image("sha256:v191", 40, "app-1.9.1"),
+		image("sha256:v190", 90, "app-1.9.0"),
+	}
+	for keepBy, want := range map[string]string{KeepByPushed: "sha256:v191", KeepBySemver: "sha256:v1100"} {
+		cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("app-")}, KeepBy: keepBy, DryRun: true}
+		summary, err := New(&fakeECR{images: images}, cfg).cleanRepository(context.Background(), "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range summary.Plan {
+			if kept := d.Decision == decisionKeep; kept != (d.Digest == want) {
+				t.Errorf("keep-by %s: %s %s (%s), want only %s kept", keepBy, d.Digest, d.Decision, d.Reason, want)
+			}
+		}
+	}
+}
+
+func TestVersionOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		tags    []string
+		want    string
+	}{
+		{"semver without pattern", "", []string{"v1.9.0", "v1.10.0"}, "v1.10.0"},
+		{"no version", "", []string{"latest"}, ""},
+		{"pattern compares semantically", `v(\d+\.\d+\.\d+)`, []string{"v1.9.0", "v1.10.0", "v1.2.0"}, "1.10.0"},
+		{"pattern keeps the v", `v\d+\.\d+\.\d+`, []string{"v1.10.0-amd64", "v1.9.0-arm64"}, "v1.10.0"},
+		{"semantic versions rank above others", `release-(.+)`, []string{"release-zeta", "release-1.0.0"}, "1.0.0"},
+		{"others compare as strings", `build-(.+)`, []string{"build-b", "build-a"}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			if tt.pattern != "" {
+				cfg.VersionPattern = regexp.MustCompile(tt.pattern)
+			}
+			if got := cfg.versionOf(aws.StringSlice(tt.tags)); got != tt.want {
+				t.Errorf("versionOf(%v) = %q, want %q", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionGroups(t *testing.T) {
+	cfg := Config{VersionPattern: regexp.MustCompile(`\d+\.\d+\.\d+`)}
+	// Sorted newest first, as retainTop passes them
+	images := []taggedImage{
+		{digest: "arm64", tags: aws.StringSlice([]string{"2.4.0-arm64"})},
+		{digest: "old", tags: aws.StringSlice([]string{"2.3.1-amd64"})},
+		{digest: "amd64", tags: aws.StringSlice([]string{"2.4.0-amd64"})},
+		{digest: "edge", tags: aws.StringSlice([]string{"edge"})},
+		{digest: "nightly", tags: aws.StringSlice([]string{"nightly"})},
+	}
+	var got [][]string
+	for _, group := range cfg.versionGroups(images) {
+		var digests []string
+		for _, image := range group {
+			digests = append(digests, image.digest)
+		}
+		got = append(got, digests)
+	}
+	want := [][]string{{"arm64", "amd64"}, {"old"}, {"edge"}, {"nightly"}}
+	if !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("versionGroups = %v, want %v", got, want)
+	}
+}
+
+func TestKeepByVersionKeepsEveryArchitecture(t *testing.T) {
+	images := []*ecr.ImageDetail{
+		image("sha256:new-amd64", 40, "app-3.0.0-amd64"),
+		image("sha256:new-arm64", 45, "app-3.0.0-arm64"),
+		image("sha256:old-amd64", 60, "app-2.9.0-amd64"),
+		image("sha256:old-arm64", 61, "app-2.9.0-arm64"),
+	}
+	cfg := Config{Retention: 30, Keep: 1, Matchers: []TagMatcher{PrefixMatcher("app-")}, KeepBy: KeepByVersion,
+		VersionPattern: regexp.MustCompile(`\d+\.\d+\.\d+`)}
+	fake := &fakeECR{images: images}
+	if _, err := New(fake, cfg).cleanRepository(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(fake.deleted)
+	if want := []string{"sha256:old-amd64", "sha256:old-arm64"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("deleted %v, want %v", fake.deleted, want)
+	}
+}
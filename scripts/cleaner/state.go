@@ -0,0 +1,263 @@
+package cleaner
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// stateEntry is one line of the state file. It records a deleted image, a
+// repository whose cleanup finished or, for incremental runs, a repository
+// that was scanned and when it is next due for review.
+type stateEntry struct {
+	Region     string     `json:"region,omitempty"`
+	Repository string     `json:"repository"`
+	Digest     string     `json:"digest,omitempty"`
+	Completed  bool       `json:"completed,omitempty"`
+	Scanned    bool       `json:"scanned,omitempty"`
+	Review     *time.Time `json:"review,omitempty"`
+	Time       time.Time  `json:"time"`
+}
+
+// StateFile records the progress of a run as JSON lines so that an
+// interrupted run can be resumed. It is safe for concurrent use, and a nil
+// *StateFile records nothing.
+type StateFile struct {
+	mu        sync.Mutex
+	file      *os.File
+	deleted   map[string]bool
+	completed map[string]bool
+	// scans holds the latest scan of each repository, by stateKey.
+	scans  map[string]stateEntry
+	closed bool
+}
+
+// OpenStateFile loads the entries of an existing state file, if any, and
+// opens it for appending.
+func OpenStateFile(path string) (*StateFile, error) {
+	s := &StateFile{deleted: make(map[string]bool), completed: make(map[string]bool), scans: make(map[string]stateEntry)}
+
+	existing, err := os.Open(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		defer existing.Close()
+		scanner := bufio.NewScanner(existing)
+		for line := 1; scanner.Scan(); line++ {
+			var entry stateEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			s.remember(entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// stateKey identifies a repository within a region.
+func stateKey(region, repoName string) string {
+	return region + "/" + repoName
+}
+
+// remember adds the entry to the in-memory state.
+func (s *StateFile) remember(entry stateEntry) {
+	key := stateKey(entry.Region, entry.Repository)
+	switch {
+	case entry.Completed:
+		s.completed[key] = true
+	case entry.Scanned:
+		s.scans[key] = entry
+	default:
+		s.deleted[key+"@"+entry.Digest] = true
+	}
+}
+
+// Resumed reports whether the state file holds progress from an earlier,
+// interrupted run. The scans recorded for incremental runs do not count.
+func (s *StateFile) Resumed() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.deleted) > 0 || len(s.completed) > 0
+}
+
+// isCompleted reports whether an earlier run finished the repository.
+func (s *StateFile) isCompleted(region, repoName string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed[stateKey(region, repoName)]
+}
+
+// isDeleted reports whether an earlier run deleted the image.
+func (s *StateFile) isDeleted(region, repoName, digest string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleted[stateKey(region, repoName)+"@"+digest]
+}
+
+// recordDeleted appends the deleted images and syncs the file, so that a
+// crash after a batch does not lose its progress. Tags deleted from images
+// that are kept are not recorded.
+func (s *StateFile) recordDeleted(region, repoName string, ids []*ecr.ImageIdentifier) error {
+	var entries []stateEntry
+	for _, id := range ids {
+		if id.ImageDigest != nil && id.ImageTag == nil {
+			entries = append(entries, stateEntry{Region: region, Repository: repoName, Digest: *id.ImageDigest, Time: time.Now()})
+		}
+	}
+	return s.append(entries...)
+}
+
+// recordCompleted marks the repository as finished.
+func (s *StateFile) recordCompleted(region, repoName string) error {
+	return s.append(stateEntry{Region: region, Repository: repoName, Completed: true, Time: time.Now()})
+}
+
+// recordScanned records that the repository was scanned, and when it is
+// next due for review; nil means only once a full scan is due.
+func (s *StateFile) recordScanned(region, repoName string, review *time.Time) error {
+	return s.append(stateEntry{Region: region, Repository: repoName, Scanned: true, Review: review, Time: time.Now()})
+}
+
+// upToDate reports whether the repository was scanned within fullScanAfter
+// and is not yet due for review, and if so until when.
+func (s *StateFile) upToDate(region, repoName string, fullScanAfter time.Duration) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scan, ok := s.scans[stateKey(region, repoName)]
+	if !ok {
+		return time.Time{}, false
+	}
+	until := scan.Time.Add(fullScanAfter)
+	if scan.Review != nil && scan.Review.Before(until) {
+		until = *scan.Review
+	}
+	return until, time.Now().Before(until)
+}
+
+// LastScan returns the time of the most recent scan recorded for an
+// incremental run, or the zero time if there is none.
+func (s *StateFile) LastScan() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last time.Time
+	for _, scan := range s.scans {
+		if scan.Time.After(last) {
+			last = scan.Time
+		}
+	}
+	return last
+}
+
+// append writes the entries and syncs the file.
+func (s *StateFile) append(entries ...stateEntry) error {
+	if s == nil || len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		s.remember(entry)
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file. It may be called more than once; later calls do
+// nothing.
+func (s *StateFile) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.file.Close()
+}
+
+// Compact rewrites the state file with only the latest scan of each
+// repository, once an incremental run has finished cleanly, and closes it.
+// The file is replaced atomically, so a crash leaves either version.
+func (s *StateFile) Compact() error {
+	if s == nil {
+		return nil
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.scans))
+	for key := range s.scans {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var data []byte
+	for _, key := range keys {
+		line, err := json.Marshal(s.scans[key])
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	name := s.file.Name()
+	if err := os.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// Remove closes and deletes the state file, once a run has finished
+// cleanly and there is nothing left to resume.
+func (s *StateFile) Remove() error {
+	if s == nil {
+		return nil
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.file.Name())
+}
@@ -0,0 +1,198 @@
+package cleaner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// ImageCounts tallies what happened to the images in one or more
+// repositories. In dry-run mode Deleted counts the images that would be
+// deleted.
+type ImageCounts struct {
+	Scanned         int `json:"scanned"`
+	Retained        int `json:"retained"`
+	Deleted         int `json:"deleted"`
+	UntaggedDeleted int `json:"untaggedDeleted"`
+	// TagsDeleted counts tags removed from images that were kept, in
+	// delete-by-tag mode.
+	TagsDeleted int `json:"tagsDeleted"`
+	// Deferred counts images that could not be deleted yet because a
+	// manifest list still references them. They are counted as retained.
+	Deferred       int   `json:"deferred"`
+	Failed         int   `json:"failed"`
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// Add accumulates other into c.
+func (c *ImageCounts) Add(other ImageCounts) {
+	c.Scanned += other.Scanned
+	c.Retained += other.Retained
+	c.Deleted += other.Deleted
+	c.UntaggedDeleted += other.UntaggedDeleted
+	c.TagsDeleted += other.TagsDeleted
+	c.Deferred += other.Deferred
+	c.Failed += other.Failed
+	c.ReclaimedBytes += other.ReclaimedBytes
+}
+
+// RepoSummary holds the image counts for a single repository.
+type RepoSummary struct {
+	Region     string `json:"region,omitempty"`
+	Repository string `json:"repository"`
+	ImageCounts
+	// OldestKept and NewestDeleted are the push times of the oldest image
+	// kept and the newest image deleted. They are omitted when there is no
+	// such image.
+	OldestKept    *time.Time `json:"oldestKept,omitempty"`
+	NewestDeleted *time.Time `json:"newestDeleted,omitempty"`
+	// NextReview is the earliest time an image kept for now may become
+	// deletable by age, which an incremental run waits for before
+	// scanning the repository again.
+	NextReview *time.Time `json:"nextReview,omitempty"`
+	// RepositoryDeleted is set when the repository was left empty and
+	// deleted, or would be in dry-run mode.
+	RepositoryDeleted bool `json:"repositoryDeleted,omitempty"`
+	// FailedImages lists the final outcome for each image that could not
+	// be deleted.
+	FailedImages []ImageFailure `json:"failedImages,omitempty"`
+	// Plan lists the decision for every image. It is only collected in
+	// dry-run mode.
+	Plan []Decision `json:"plan,omitempty"`
+	// Lifecycle reconciles the plan with Config.CompareLifecycle.
+	Lifecycle *LifecycleComparison `json:"lifecycle,omitempty"`
+}
+
+// ImageFailure records an image, or a tag in delete-by-tag mode, that
+// could not be deleted.
+type ImageFailure struct {
+	Digest string `json:"digest,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// newImageFailures returns a failure with the given code and reason for
+// each identifier.
+func newImageFailures(ids []*ecr.ImageIdentifier, code, reason string) []ImageFailure {
+	failures := make([]ImageFailure, 0, len(ids))
+	for _, id := range ids {
+		failures = append(failures, ImageFailure{
+			Digest: aws.StringValue(id.ImageDigest),
+			Tag:    aws.StringValue(id.ImageTag),
+			Code:   code,
+			Reason: reason,
+		})
+	}
+	return failures
+}
+
+// RunSummary is the machine-readable result of a cleanup run across one
+// or more regions.
+type RunSummary struct {
+	Regions      []string      `json:"regions"`
+	DryRun       bool          `json:"dryRun"`
+	Repositories []RepoSummary `json:"repositories"`
+	Totals       ImageCounts   `json:"totals"`
+	// Groups totals the repositories by name prefix when grouping is on.
+	Groups []GroupSummary `json:"groups,omitempty"`
+	// FailedRepositories counts repositories whose images could not be
+	// listed, and FailedRegions the regions that could not be scanned.
+	// Errors gives the reason for each failed repository.
+	FailedRepositories int         `json:"failedRepositories"`
+	FailedRegions      []string    `json:"failedRegions,omitempty"`
+	Errors             []RepoError `json:"errors,omitempty"`
+	// SkippedRepositories lists the repositories left alone because they
+	// are protected, do not match the resource tag filter, are managed by
+	// an ECR lifecycle policy or could not be found.
+	SkippedRepositories []string `json:"skippedRepositories,omitempty"`
+	// Aborted is set when the run stopped early, after an error with
+	// fail-fast or because it timed out or was interrupted.
+	Aborted         bool    `json:"aborted"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// RepoError records why a repository could not be cleaned up.
+type RepoError struct {
+	Region     string `json:"region,omitempty"`
+	Repository string `json:"repository"`
+	Error      string `json:"error"`
+}
+
+// Merge accumulates the results of other into s. The duration is left for
+// the caller to set.
+func (s *RunSummary) Merge(other RunSummary) {
+	s.Regions = append(s.Regions, other.Regions...)
+	s.Repositories = append(s.Repositories, other.Repositories...)
+	s.Totals.Add(other.Totals)
+	s.FailedRepositories += other.FailedRepositories
+	s.Errors = append(s.Errors, other.Errors...)
+	s.FailedRegions = append(s.FailedRegions, other.FailedRegions...)
+	s.SkippedRepositories = append(s.SkippedRepositories, other.SkippedRepositories...)
+	s.Aborted = s.Aborted || other.Aborted
+}
+
+// GroupSummary totals the image counts of the repositories sharing a name
+// prefix.
+type GroupSummary struct {
+	Group        string `json:"group"`
+	Repositories int    `json:"repositories"`
+	ImageCounts
+}
+
+// GroupBy buckets the repositories by the first depth segments of their
+// names, so that team-a/web and team-a/api both fall in team-a at depth 1.
+// The groups are sorted by name.
+func (s RunSummary) GroupBy(depth int) []GroupSummary {
+	groups := make(map[string]*GroupSummary)
+	for _, repo := range s.Repositories {
+		key := repoGroup(repo.Repository, depth)
+		group, ok := groups[key]
+		if !ok {
+			group = &GroupSummary{Group: key}
+			groups[key] = group
+		}
+		group.Repositories++
+		group.Add(repo.ImageCounts)
+	}
+
+	result := make([]GroupSummary, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Group < result[j].Group })
+	return result
+}
+
+// repoGroup returns the first depth "/"-separated segments of name, or the
+// whole name if it has fewer.
+func repoGroup(name string, depth int) string {
+	segments := strings.SplitN(name, "/", depth+1)
+	if len(segments) <= depth {
+		return name
+	}
+	return strings.Join(segments[:depth], "/")
+}
+
+// HasErrors reports whether any region, repository or deletion failed.
+func (s RunSummary) HasErrors() bool {
+	return s.Totals.Failed > 0 || s.FailedRepositories > 0 || len(s.FailedRegions) > 0
+}
+
+// FormatBytes renders a byte count in human-readable units.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
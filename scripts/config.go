@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the YAML form of the cleanup settings loaded with -config.
+// Keys mirror the flag names. Pointer fields distinguish settings that are
+// absent from those set to their zero value.
+type fileConfig struct {
+	Region      *string        `yaml:"region"`
+	Regions     []string       `yaml:"regions"`
+	Retention   *int           `yaml:"retention"`
+	Prefixes    []string       `yaml:"prefixes"`
+	Keep        *int           `yaml:"keep"`
+	KeepMap     map[string]int `yaml:"keep-map"`
+	KeepByRepo  map[string]int `yaml:"keep-by-repo"`
+	ProtectTags []string       `yaml:"protect-tags"`
+	RepoFilter  []string       `yaml:"repo-filter"`
+	RepoExclude []string       `yaml:"repo-exclude"`
+	DryRun      *bool          `yaml:"dry-run"`
+}
+
+// loadConfigFile reads and strictly decodes a YAML config file, rejecting
+// unknown keys and values of the wrong type.
+func loadConfigFile(path string) (fileConfig, error) {
+	var fc fileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fc); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+	return fc, nil
+}
+
+// apply copies the settings from the config file into opts, skipping any
+// whose flag was given on the command line so that flags take precedence.
+func (fc fileConfig) apply(opts *options, setFlags map[string]bool) {
+	if fc.Region != nil && !setFlags["region"] {
+		opts.region = *fc.Region
+	}
+	if fc.Regions != nil && !setFlags["regions"] {
+		opts.regionList = strings.Join(fc.Regions, ",")
+	}
+	if fc.Retention != nil && !setFlags["retention"] {
+		opts.retention = *fc.Retention
+	}
+	if fc.Prefixes != nil && !setFlags["prefixes"] {
+		opts.prefixList = strings.Join(fc.Prefixes, ",")
+	}
+	if fc.Keep != nil && !setFlags["keep"] {
+		opts.keep = *fc.Keep
+	}
+	if fc.KeepMap != nil && !setFlags["keep-map"] {
+		var entries []string
+		for prefix, count := range fc.KeepMap {
+			entries = append(entries, prefix+"="+strconv.Itoa(count))
+		}
+		opts.keepList = strings.Join(entries, ",")
+	}
+	if fc.KeepByRepo != nil && !setFlags["keep-by-repo"] {
+		var entries []string
+		for repo, count := range fc.KeepByRepo {
+			entries = append(entries, repo+"="+strconv.Itoa(count))
+		}
+		opts.keepRepoList = strings.Join(entries, ",")
+	}
+	if fc.ProtectTags != nil && !setFlags["protect-tags"] {
+		opts.protectList = strings.Join(fc.ProtectTags, ",")
+	}
+	if fc.RepoFilter != nil && !setFlags["repo-filter"] {
+		opts.repoFilter = strings.Join(fc.RepoFilter, ",")
+	}
+	if fc.RepoExclude != nil && !setFlags["repo-exclude"] {
+		opts.repoExclude = strings.Join(fc.RepoExclude, ",")
+	}
+	if fc.DryRun != nil && !setFlags["dry-run"] {
+		opts.dryRun = *fc.DryRun
+	}
+}
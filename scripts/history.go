@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"scripts/cleaner"
+)
+
+// historyRecord is one line of the -history-file, describing the run in a
+// single region.
+type historyRecord struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Region          string    `json:"region"`
+	DryRun          bool      `json:"dryRun"`
+	Repositories    int       `json:"repositoriesProcessed"`
+	Deleted         int       `json:"imagesDeleted"`
+	ReclaimedBytes  int64     `json:"bytesReclaimed"`
+	Failed          int       `json:"failed"`
+	Aborted         bool      `json:"aborted,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds"`
+}
+
+// appendHistory appends one JSON line per region to the history file,
+// creating it if needed. All the lines go out in a single append-mode
+// write, so records from concurrent runs do not interleave.
+func appendHistory(path string, started time.Time, dryRun bool, regions []cleaner.RunSummary) error {
+	var data []byte
+	for _, summary := range regions {
+		for _, region := range summary.Regions {
+			line, err := json.Marshal(historyRecord{
+				Timestamp:       started.UTC(),
+				Region:          region,
+				DryRun:          dryRun,
+				Repositories:    len(summary.Repositories),
+				Deleted:         summary.Totals.Deleted,
+				ReclaimedBytes:  summary.Totals.ReclaimedBytes,
+				Failed:          summary.Totals.Failed + summary.FailedRepositories + len(summary.FailedRegions),
+				Aborted:         summary.Aborted,
+				DurationSeconds: summary.DurationSeconds,
+			})
+			if err != nil {
+				return err
+			}
+			data = append(append(data, line...), '\n')
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
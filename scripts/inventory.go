@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+
+	"scripts/cleaner"
+)
+
+// repoList is the JSON output of -list-repos.
+type repoList struct {
+	Repositories []cleaner.RepoListing `json:"repositories"`
+	Images       int                   `json:"images"`
+}
+
+// repoStats is the JSON output of -stats.
+type repoStats struct {
+	Repositories []cleaner.RepoStats `json:"repositories"`
+	Totals       cleaner.RepoStats   `json:"totals"`
+}
+
+// listRepos prints the repositories each region's filters select, with
+// their image counts, as text or, with -output json, as JSON on stdout.
+func listRepos(ctx context.Context, opts options, regions []string) error {
+	list := repoList{Repositories: []cleaner.RepoListing{}}
+	for _, region := range regions {
+		c, err := newInventoryCleaner(opts, region)
+		if err != nil {
+			return err
+		}
+		listings, err := c.ListRepositories(ctx)
+		if err != nil {
+			return fmt.Errorf("region %s: %w", region, err)
+		}
+		list.Repositories = append(list.Repositories, listings...)
+	}
+
+	logger.Summaryf("📚 Repositories in scope:")
+	for _, repo := range list.Repositories {
+		logger.Summaryf("  %s/%s: %d images", repo.Region, repo.Repository, repo.Images)
+		list.Images += repo.Images
+	}
+	logger.Summaryf("  Total: %d repositories, %d images", len(list.Repositories), list.Images)
+
+	if opts.output == outputJSON {
+		return writeStdoutJSON(list)
+	}
+	return nil
+}
+
+// printStats prints an inventory of the images in each repository the
+// filters select, and their grand total, as a table or, with -output json,
+// as JSON on stdout.
+func printStats(ctx context.Context, opts options, regions []string) error {
+	stats := repoStats{Repositories: []cleaner.RepoStats{}}
+	for _, region := range regions {
+		c, err := newInventoryCleaner(opts, region)
+		if err != nil {
+			return err
+		}
+		repos, err := c.Stats(ctx)
+		if err != nil {
+			return fmt.Errorf("region %s: %w", region, err)
+		}
+		stats.Repositories = append(stats.Repositories, repos...)
+	}
+	for _, repo := range stats.Repositories {
+		stats.Totals.Add(repo)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tIMAGES\tTAGGED\tUNTAGGED\tTAGS\tSIZE\tOLDEST PUSH\tNEWEST PUSH")
+	row := func(name string, s cleaner.RepoStats) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", name, s.Images, s.Tagged, s.Untagged, s.Tags,
+			cleaner.FormatBytes(s.SizeBytes), formatPushDate(s.OldestPush), formatPushDate(s.NewestPush))
+	}
+	for _, repo := range stats.Repositories {
+		row(repo.Region+"/"+repo.Repository, repo)
+	}
+	row(fmt.Sprintf("Total (%d repositories)", len(stats.Repositories)), stats.Totals)
+	w.Flush()
+	logger.Summaryf("📊 Repository stats:")
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		logger.Summaryf("%s", line)
+	}
+
+	if opts.output == outputJSON {
+		return writeStdoutJSON(stats)
+	}
+	return nil
+}
+
+// newInventoryCleaner returns a Cleaner for the region that -list-repos and
+// -stats use to look up the repositories in scope.
+func newInventoryCleaner(opts options, region string) (*cleaner.Cleaner, error) {
+	sess, err := newSession(opts, region)
+	if err != nil {
+		return nil, fmt.Errorf("region %s: error creating AWS session: %w", region, err)
+	}
+	awsConfig := clientConfig(sess, opts)
+	c := cleaner.New(newECRClient(sess, awsConfig, opts), opts.policy)
+	c.Logger = logger
+	c.Region = region
+	if opts.discoverByTags {
+		c.Tagging = resourcegroupstaggingapi.New(sess, awsConfig)
+	}
+	return c, nil
+}
+
+// formatPushDate renders a push time as a date, or "-" when there is none.
+func formatPushDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.DateOnly)
+}
+
+// writeStdoutJSON writes v to stdout as indented JSON.
+func writeStdoutJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an append-only log file that is renamed with a timestamp
+// suffix and replaced by a fresh file once it grows past a size limit. It
+// is safe for concurrent use.
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+// OpenFile opens path for appending, creating it and its parent directory
+// if needed. A maxBytes of zero disables rotation.
+func OpenFile(path string, maxBytes int64) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &RotatingFile{path: path, maxBytes: maxBytes}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at f.path and records its current size.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and opens a new
+// one in its place. A rotation within the same second as an earlier one
+// adds a sequence number, so no rotated file is overwritten.
+func (f *RotatingFile) rotate() error {
+	stamp := fmt.Sprintf("%s.%s", f.path, time.Now().Format("20060102-150405"))
+	rotated := stamp
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(rotated); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return err
+		}
+		rotated = fmt.Sprintf("%s.%d", stamp, n)
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Write appends p, rotating first if the file has reached its size limit.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the underlying file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileKeepsEveryRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cleanup.log")
+	f, err := OpenFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each write fills the file, so every later write rotates it, several
+	// times within the same second
+	lines := []string{"first-----\n", "second----\n", "third-----\n", "fourth----\n"}
+	for _, line := range lines {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != len(lines)-1 {
+		t.Fatalf("got %d rotated files, want %d: %v", len(rotated), len(lines)-1, rotated)
+	}
+	var contents []string
+	for _, name := range append(rotated, path) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	for _, line := range lines {
+		found := false
+		for _, content := range contents {
+			if content == line {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("line %q was lost; files hold %q", strings.TrimSpace(line), contents)
+		}
+	}
+}
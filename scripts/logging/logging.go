@@ -0,0 +1,211 @@
+// Package logging writes the cleanup log either as the traditional
+// "[TAG] message" text lines or as one JSON object per entry.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels, from most to least verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the lower-case level name used in JSON entries.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseLevel returns the level with the given name: debug, info, warn
+// or error.
+func ParseLevel(name string) (Level, error) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+}
+
+// tag returns the text-format tag for the level.
+func (l Level) tag() string {
+	if l == LevelWarn {
+		return "WARNING"
+	}
+	return strings.ToUpper(l.String())
+}
+
+// Entry is a single log record. Region, Repository, Digest and Action are
+// optional; in text output Action replaces the level as the line tag.
+type Entry struct {
+	Level      Level
+	Region     string
+	Repository string
+	Digest     string
+	Action     string
+	Message    string
+}
+
+// jsonEntry is the wire form of an Entry in JSON format.
+type jsonEntry struct {
+	Level      string `json:"level"`
+	Timestamp  string `json:"timestamp"`
+	Region     string `json:"region,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Message    string `json:"message"`
+}
+
+// Logger writes entries to an io.Writer. It is safe for concurrent use;
+// each entry is written as a single uninterrupted line.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	level  Level
+
+	// parent, when set, writes the entries of a logger returned by
+	// WithRegion, marked with region.
+	parent *Logger
+	region string
+}
+
+// New returns a Logger writing to out in the given format. It logs
+// entries at info level and above until SetLevel is called.
+func New(out io.Writer, format string) *Logger {
+	return &Logger{out: out, format: format, level: LevelInfo}
+}
+
+// WithRegion returns a Logger that writes through l, marking each entry
+// with the region: a "[region]" prefix in text output, and a region field
+// in JSON. It shares the minimum level of l.
+func (l *Logger) WithRegion(region string) *Logger {
+	return &Logger{parent: l, region: region}
+}
+
+// SetLevel sets the minimum level of the entries that are written.
+func (l *Logger) SetLevel(level Level) {
+	if l.parent != nil {
+		l.parent.SetLevel(level)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Enabled reports whether entries at the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	if l.parent != nil {
+		return l.parent.Enabled(level)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level
+}
+
+// Discard returns a Logger that drops every entry.
+func Discard() *Logger {
+	return New(io.Discard, FormatText)
+}
+
+// Log writes the entry, unless it is below the minimum level.
+func (l *Logger) Log(e Entry) {
+	if !l.Enabled(e.Level) {
+		return
+	}
+	l.write(e)
+}
+
+// write formats and writes the entry whatever its level.
+func (l *Logger) write(e Entry) {
+	if l.parent != nil {
+		e.Region = l.region
+		l.parent.write(e)
+		return
+	}
+	now := time.Now()
+
+	var line []byte
+	if l.format == FormatJSON {
+		line, _ = json.Marshal(jsonEntry{
+			Level:      e.Level.String(),
+			Timestamp:  now.Format(time.RFC3339),
+			Region:     e.Region,
+			Repository: e.Repository,
+			Digest:     e.Digest,
+			Action:     e.Action,
+			Message:    e.Message,
+		})
+	} else {
+		tag := e.Level.tag()
+		if e.Action != "" {
+			tag = strings.ToUpper(e.Action)
+		}
+		message := e.Message
+		if e.Region != "" {
+			message = "[" + e.Region + "] " + message
+		}
+		line = fmt.Appendf(nil, "%s [%s] %s", now.Format("2006/01/02 15:04:05"), tag, message)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+// Infof logs a formatted message at info level.
+func (l *Logger) Infof(format string, args ...any) {
+	l.Log(Entry{Level: LevelInfo, Message: fmt.Sprintf(format, args...)})
+}
+
+// Summaryf logs a formatted message at info level even when the minimum
+// level is higher, so that the run summary is always printed.
+func (l *Logger) Summaryf(format string, args ...any) {
+	l.write(Entry{Level: LevelInfo, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf logs a formatted message at warn level.
+func (l *Logger) Warnf(format string, args ...any) {
+	l.Log(Entry{Level: LevelWarn, Message: fmt.Sprintf(format, args...)})
+}
+
+// Errorf logs a formatted message at error level.
+func (l *Logger) Errorf(format string, args ...any) {
+	l.Log(Entry{Level: LevelError, Message: fmt.Sprintf(format, args...)})
+}
+
+// Fatalf logs a formatted message at error level and exits with status 1.
+func (l *Logger) Fatalf(format string, args ...any) {
+	l.Errorf(format, args...)
+	os.Exit(1)
+}
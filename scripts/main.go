@@ -1,180 +1,1768 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"golang.org/x/term"
+	"golang.org/x/time/rate"
+
+	"scripts/cleaner"
+	"scripts/logging"
 )
 
-var logger *log.Logger
+// logger writes to the terminal and the log file. It is also handed to the
+// cleaner, whose workers share it.
+var logger *logging.Logger
 
-func setupLogger() {
-	logFile, err := os.OpenFile("ecr-image-cleanup.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		log.Fatalf("❌ Failed to open log file: %v", err)
+// setupLogger writes the log to the terminal and, unless -log-stdout-only
+// is set, to the log file, in the format selected by -log-format. In JSON
+// output mode the terminal copy goes to stderr so stdout only carries the
+// summary document.
+func setupLogger(opts options) {
+	var terminal io.Writer = os.Stdout
+	if opts.output == outputJSON {
+		terminal = os.Stderr
+	}
+	if opts.logStdoutOnly {
+		logger = logging.New(terminal, opts.logFormat)
+	} else {
+		logFile, err := logging.OpenFile(opts.logFile, int64(opts.logMaxSizeMB)*1024*1024)
+		if err != nil {
+			log.Fatalf("❌ Failed to open log file: %v", err)
+		}
+		logger = logging.New(io.MultiWriter(terminal, logFile), opts.logFormat)
 	}
 
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
-	logger = log.New(multiWriter, "", log.Ldate|log.Ltime)
+	// An invalid level is reported by validate
+	if level, err := logging.ParseLevel(opts.logLevel); err == nil {
+		logger.SetLevel(level)
+	}
 }
 
-func main() {
-	setupLogger()
+// Supported values for the -match-mode flag.
+const (
+	matchPrefix = "prefix"
+	matchRegex  = "regex"
+)
+
+// Supported values for the -output flag.
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputTable = "table"
+)
+
+// sinceLastRun is the -since value for incremental runs.
+const sinceLastRun = "last-run"
+
+// options holds the settings for a single cleanup run.
+type options struct {
+	region string
+	// regionSource says where the region came from, for the log.
+	regionSource      string
+	regionList        string
+	profile           string
+	sharedConfig      bool
+	webIdentity       bool
+	roleArn           string
+	externalID        string
+	retention         int
+	retentionDuration time.Duration
+	untaggedRetention int
+	minSizeMB         int
+	unpulledDays      int
+	gracePeriod       time.Duration
+	graceList         string
+	beforeDate        string
+	cutoffMode        string
+	keep              int
+	minKeep           int
+	tagCountThreshold int
+	keepList          string
+	keepRepoList      string
+	prefixList        string
+	prefixesFile      string
+	matcherFile       string
+	policyFile        string
+	excludePrefixes   string
+	matchMode         string
+	keepBy            string
+	versionRegex      string
+	ageFromTagRegex   string
+	sortOrder         string
+	includeUnmatched  bool
+	dryRun            bool
+	confirmDelete     bool
+	confirmEach       bool
+	// interactive is set when the options were entered at the prompt.
+	interactive bool
+	// repoList names the repositories to clean up; repoFilter and
+	// repoExclude are comma-separated glob patterns matched against
+	// repository names.
+	repoList    string
+	repoFilter  string
+	repoExclude string
+	// deleteEmptyRepos is a comma-separated list of glob patterns of
+	// repositories that may be deleted once empty.
+	deleteEmptyRepos string
+	// protectReposFile names a file listing repositories never touched.
+	protectReposFile string
+	protectList      string
+	protectDigests   string
+	mediaTypes       string
+	resourceTags     string
+	discoverByTags   bool
+	// protectDigestsFile names a file listing image digests never deleted.
+	protectDigestsFile string
+	// deleteUntagged controls whether untagged images are deleted.
+	deleteUntagged  bool
+	deleteByTag     bool
+	followReferrers bool
+	skipLifecycle   bool
+	scanFindings    bool
+	minSeverity     string
+	output          string
+	logFormat       string
+	logLevel        string
+	logFile         string
+	logStdoutOnly   bool
+	logMaxSizeMB    int
+	reportPath      string
+	failFast        bool
+	ignoreErrors    bool
+	planOnly        bool
+	simulate        string
+	simulation      *simulatedECR
+	explain         bool
+	lifecycleFile   string
+	reportOnly      bool
+	listRepos       bool
+	stats           bool
+	groupDepth      int
+	snsTopicArn     string
+	emitMetrics     bool
+	pushgateway     string
+	webhookURL      string
+	webhookHeaders  []string
+	webhookTimeout  time.Duration
+	endpointURL     string
+	useFIPS         bool
+	concurrency     int
+	parallelRegions bool
+	skipPreflight   bool
+	deleteWorkers   int
+	progress        time.Duration
+	quiet           bool
+	verbose         bool
+	quietEmpty      bool
+	maxRetries      int
+	rps             float64
+	timeout         time.Duration
+	maxDelete       int
+	maxDeleteRepo   int
+	minRepos        int
+	untaggedFirst   bool
+	maxReclaimMB    int
+	stateFile       string
+	since           string
+	fullScanAfter   time.Duration
+	historyFile     string
+	serveAddr       string
+	pprofAddr       string
+
+	// policy is derived from the raw flag values by parse.
+	policy cleaner.Config
+	// state is opened from stateFile by main.
+	state *cleaner.StateFile
+	// prompter asks for each deletion with -confirm-each.
+	prompter *cleaner.Prompter
+	// limiter, built by parse from rps, paces the ECR calls of every
+	// region and worker.
+	limiter *rate.Limiter
+}
+
+// parseFlags reads the command-line flags, layered over the -config file
+// when one is given. When no flags are supplied the user is prompted
+// interactively, preserving the original behavior.
+func parseFlags() (options, error) {
+	var opts options
+	var configPath string
+
+	flag.StringVar(&configPath, "config", "", "YAML file with cleanup settings; flags override its values")
+
+	flag.StringVar(&opts.region, "region", "", "AWS region to clean up (e.g., us-east-1)")
+	flag.StringVar(&opts.regionList, "regions", "", "Comma-separated AWS regions to clean up in turn (e.g., us-east-1,eu-west-1)")
+	flag.StringVar(&opts.profile, "profile", "", "AWS named profile to use (default credentials chain when empty)")
+	flag.BoolVar(&opts.webIdentity, "web-identity", false, "Assume AWS_ROLE_ARN with the token in AWS_WEB_IDENTITY_TOKEN_FILE, as set up by EKS IAM Roles for Service Accounts, instead of the default credential chain")
+	flag.BoolVar(&opts.sharedConfig, "shared-config", false, "Load ~/.aws/config, for SSO and credential_process profiles, even without -profile or AWS_SDK_LOAD_CONFIG")
+	flag.StringVar(&opts.roleArn, "assume-role-arn", "", "IAM role ARN to assume for cross-account cleanup")
+	flag.StringVar(&opts.externalID, "external-id", "", "External ID to pass when assuming -assume-role-arn")
+	flag.StringVar(&opts.endpointURL, "endpoint-url", "", "Custom ECR endpoint URL, e.g. a VPC endpoint (requires a single region)")
+	flag.BoolVar(&opts.useFIPS, "use-fips", false, "Use the FIPS endpoints of the AWS services")
+	flag.IntVar(&opts.retention, "retention", 0, "Retention period in days; older images are deleted")
+	flag.DurationVar(&opts.retentionDuration, "retention-duration", 0, "Retention period as a duration (e.g., 72h, 30m); overrides -retention when set")
+	flag.DurationVar(&opts.gracePeriod, "grace-period", 0, "Never delete images pushed within this duration (e.g., 24h), regardless of retention and keep rules")
+	flag.StringVar(&opts.graceList, "grace-map", "", "Per-prefix grace periods overriding -grace-period for images with a matching tag (e.g., release-=168h,hotfix-=0)")
+	flag.IntVar(&opts.untaggedRetention, "untagged-retention", 0, "Retention period in days for untagged images; 0 deletes them regardless of age")
+	flag.IntVar(&opts.unpulledDays, "unpulled-days", 0, "Delete images last pulled more than this many days ago, whatever their push time; never-pulled images still use -retention. 0 disables")
+	flag.StringVar(&opts.ageFromTagRegex, "age-from-tag-regex", "", "Regular expression whose first capture group (or whole match) is a date in a tag, such as YYYYMMDD in build-(\\d{8}), to age tagged images by instead of their push time")
+	flag.IntVar(&opts.minSizeMB, "min-size-mb", 0, "Only delete images larger than this size in MB; 0 disables the size check")
+	flag.StringVar(&opts.beforeDate, "before", "", "Absolute cutoff date (RFC3339 or YYYY-MM-DD); images pushed earlier are deletion candidates")
+	flag.StringVar(&opts.cutoffMode, "cutoff-mode", cleaner.CutoffAnd, "How -before combines with -retention: and (both must pass) or or (either)")
+	flag.IntVar(&opts.keep, "keep", 2, "Number of most recent images to keep per tag prefix")
+	flag.IntVar(&opts.minKeep, "min-keep", 1, "Minimum number of most recent images kept in every repository regardless of age")
+	flag.IntVar(&opts.tagCountThreshold, "tag-count-threshold", 0, "Never delete images carrying more than this many tags; 0 disables the check")
+	flag.BoolVar(&opts.includeUnmatched, "include-unmatched", true, "Delete old tagged images whose tags match no prefix; set -include-unmatched=false to keep them")
+	flag.StringVar(&opts.keepList, "keep-map", "", "Per-prefix keep counts (e.g., prod=10,dev=2); unlisted prefixes use -keep")
+	flag.StringVar(&opts.keepRepoList, "keep-by-repo", "", "Per-repository keep counts (e.g., repoA=20,repoB=1), overriding -keep and the per-prefix counts for every prefix")
+	flag.StringVar(&opts.prefixList, "prefixes", "", "Comma-separated tag prefixes to keep (e.g., latest,dev,main)")
+	flag.StringVar(&opts.prefixesFile, "prefixes-file", "", "File of tag prefixes, one per line, merged with -prefixes")
+	flag.StringVar(&opts.excludePrefixes, "exclude-prefixes", "", "Comma-separated tag prefixes whose images are always kept, whatever the keep counts (e.g., release-,hotfix-)")
+	flag.StringVar(&opts.policyFile, "policy-file", "", "JSON file of per-prefix rules (keepCount, maxAgeDays, alwaysKeep) and a default rule for unmatched tags")
+	flag.StringVar(&opts.matcherFile, "tag-allow-regex-file", "", "File of tag matchers, one per line: a literal prefix, or a regular expression after re:; merged with -prefixes")
+	flag.StringVar(&opts.matchMode, "match-mode", matchPrefix, "How -prefixes are matched against tags: prefix or regex")
+	flag.StringVar(&opts.sortOrder, "sort", cleaner.SortAsc, "Order in which repositories are processed, by name: asc or desc")
+	flag.StringVar(&opts.keepBy, "keep-by", cleaner.KeepByPushed, "Which images -keep retains per prefix: pushed (most recently pushed), semver (highest versions) or version (every image of the most recently pushed versions)")
+	flag.StringVar(&opts.versionRegex, "version-regex", "", "With -keep-by version, regular expression whose first capture group (or whole match) is the version in a tag (e.g., v(\\d+\\.\\d+\\.\\d+)); defaults to the semantic version")
+	flag.BoolVar(&opts.dryRun, "dry-run", true, "Only show what would be deleted; this is the default unless -confirm-delete is given")
+	flag.BoolVar(&opts.confirmDelete, "confirm-delete", false, "Actually delete images; without it (or -dry-run=false) the run is a dry run")
+	flag.BoolVar(&opts.confirmEach, "confirm-each", false, "Ask before each deletion, processing one repository at a time; answer a to approve the rest of a repository")
+	flag.IntVar(&opts.groupDepth, "group-depth", 1, "Group the end-of-run summary by the first N \"/\"-separated segments of repository names; 0 disables grouping")
+	flag.BoolVar(&opts.reportOnly, "report-only", false, "Only report, per repository and in total, how many images and bytes would be deleted; never calls a delete API")
+	flag.BoolVar(&opts.listRepos, "list-repos", false, "Print the repositories the filters select, with their image counts, and exit without making deletion decisions")
+	flag.BoolVar(&opts.stats, "stats", false, "Print an inventory of the images in each repository the filters select, with a grand total, and exit without deleting anything")
+	flag.BoolVar(&opts.explain, "explain", false, "In a dry run, log the exact BatchDeleteImage requests, in batches of up to 100 image IDs, that a real run would send")
+	flag.StringVar(&opts.lifecycleFile, "compare-lifecycle", "", "In a dry run, evaluate the ECR lifecycle policy in this JSON file against each repository and report where it and the plan diverge")
+	flag.StringVar(&opts.simulate, "simulate", "", "Run the retention rules against the repositories and images in this JSON fixture file and print the plan, without any AWS call")
+	flag.BoolVar(&opts.planOnly, "plan-only", false, "Print the per-repository keep/delete plan and exit without deleting or notifying")
+	flag.StringVar(&opts.repoList, "repos", "", "Comma-separated repository names to clean up, instead of listing every repository")
+	flag.StringVar(&opts.repoFilter, "repo-filter", "", "Comma-separated glob patterns; only matching repositories are processed (e.g., team-a/*)")
+	flag.StringVar(&opts.repoExclude, "repo-exclude", "", "Comma-separated glob patterns; matching repositories are skipped")
+	flag.StringVar(&opts.resourceTags, "resource-tag", "", "Only process repositories whose AWS resource tags match these key=value pairs (e.g., Environment=dev)")
+	flag.BoolVar(&opts.discoverByTags, "discover-by-tags", false, "Find the -resource-tag repositories with the Resource Groups Tagging API instead of listing every repository")
+	flag.StringVar(&opts.protectReposFile, "protect-repos-file", "", "File of repository names, one per line, that are skipped entirely")
+	flag.StringVar(&opts.protectList, "protect-tags", "", "Comma-separated exact tags that are never deleted (e.g., release-stable,prod-pinned)")
+	flag.StringVar(&opts.protectDigests, "protect-digests", "", "Comma-separated image digests that are never deleted, whatever their tags (e.g., sha256:...)")
+	flag.StringVar(&opts.protectDigestsFile, "protect-digests-file", "", "File of image digests, one per line, that are never deleted; merged with -protect-digests")
+	flag.StringVar(&opts.mediaTypes, "media-types", "", "Comma-separated manifest or artifact media types to delete; other images are kept (e.g., application/vnd.docker.distribution.manifest.v2+json)")
+	flag.StringVar(&opts.reportPath, "report", "", "Write a CSV report of every image considered to this file")
+	flag.BoolVar(&opts.failFast, "fail-fast", false, "Abort on the first error instead of completing the sweep")
+	flag.BoolVar(&opts.ignoreErrors, "ignore-errors", false, "Exit with status 0 even if repositories, regions or deletions failed; the errors are still logged and summarized")
+	flag.StringVar(&opts.snsTopicArn, "sns-topic-arn", "", "SNS topic to notify with a summary of the run")
+	flag.BoolVar(&opts.emitMetrics, "emit-metrics", false, "Publish run metrics to CloudWatch under the ECRCleanup namespace")
+	flag.StringVar(&opts.pushgateway, "pushgateway-url", "", "Prometheus pushgateway to push per-region run metrics to after the run (e.g., http://pushgateway:9091)")
+	flag.StringVar(&opts.webhookURL, "webhook-url", "", "POST the JSON run summary to this URL after the run")
+	flag.Func("webhook-header", "Header to send with the webhook, as \"Name: value\" (e.g., \"Authorization: Bearer token\"); may be repeated", func(v string) error {
+		opts.webhookHeaders = append(opts.webhookHeaders, v)
+		return nil
+	})
+	flag.DurationVar(&opts.webhookTimeout, "webhook-timeout", 10*time.Second, "Timeout of each webhook request")
+	flag.StringVar(&opts.output, "output", outputText, "Summary output format: text, json, or table for an aligned per-repository table at the end, which quiets the per-image log lines unless -verbose is set")
+	flag.StringVar(&opts.logFormat, "log-format", logging.FormatText, "Log format: text or json")
+	flag.StringVar(&opts.logLevel, "log-level", "info", "Minimum log level: debug (adds every KEEP line), info, warn (only problems and the summary) or error")
+	flag.StringVar(&opts.logFile, "log-file", "ecr-image-cleanup.log", "Path of the log file")
+	flag.BoolVar(&opts.logStdoutOnly, "log-stdout-only", false, "Log to the terminal only, without a log file")
+	flag.IntVar(&opts.logMaxSizeMB, "log-max-size-mb", 0, "Rotate the log file once it exceeds this size in MB (0 disables rotation)")
+	flag.IntVar(&opts.maxDelete, "max-delete", 0, "Abort before deleting anything if more images than this would be deleted in total; 0 means no limit")
+	flag.BoolVar(&opts.untaggedFirst, "untagged-first", false, "With -max-delete, delete up to the limit instead of aborting, untagged images first and then the oldest tagged images")
+	flag.IntVar(&opts.maxReclaimMB, "max-reclaim-mb", 0, "Delete at most this many MB per run, largest images first (untagged first with -untagged-first), leaving the rest for the next run; 0 means no limit")
+	flag.IntVar(&opts.maxDeleteRepo, "max-delete-per-repo", 0, "Leave a repository untouched if more images than this would be deleted from it; 0 means no limit")
+	flag.IntVar(&opts.minRepos, "min-repos", 0, "Abort a region without cleaning up if fewer repositories than this are found, in case the credentials point at the wrong account; 0 disables the guard")
+	flag.StringVar(&opts.serveAddr, "serve", "", "Run as a service listening on this address (e.g., :8080), with GET /healthz and POST /run to trigger a cleanup")
+	flag.StringVar(&opts.pprofAddr, "pprof", "", "Diagnostics: serve net/http/pprof profiles on this address (e.g., localhost:6060) during the run")
+	flag.StringVar(&opts.historyFile, "history-file", "", "Append a JSON line per region with the totals of each run to this file")
+	flag.StringVar(&opts.since, "since", "", "last-run: scan only the repositories whose images may have expired since the last run recorded in -state-file")
+	flag.DurationVar(&opts.fullScanAfter, "full-scan-after", 24*time.Hour, "With -since last-run, scan every repository at least this often")
+	flag.StringVar(&opts.stateFile, "state-file", "", "Record deleted images in this JSON lines file and, on restart, skip what an interrupted run already did")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "Overall deadline for the run (e.g., 30m); 0 means no limit")
+	flag.IntVar(&opts.concurrency, "concurrency", 5, "Number of repositories to process in parallel")
+	flag.BoolVar(&opts.skipPreflight, "skip-preflight", false, "Skip the check, before a real run, that images may be deleted in every region")
+	flag.BoolVar(&opts.parallelRegions, "parallel-regions", false, "Clean up the regions concurrently, up to -concurrency at a time, instead of one after another")
+	flag.IntVar(&opts.deleteWorkers, "delete-concurrency", 1, "Number of delete batches in flight at once, within a repository and across the run")
+	flag.DurationVar(&opts.progress, "progress-interval", 30*time.Second, "How often to log a progress heartbeat (e.g., 10s, 1m); 0 disables it")
+	flag.BoolVar(&opts.quiet, "quiet", false, "Suppress per-image log lines, keeping repository summaries and progress heartbeats")
+	flag.BoolVar(&opts.verbose, "verbose", false, "With -output table, keep the per-image log lines")
+	flag.BoolVar(&opts.quietEmpty, "no-repo-empty-warning", false, "Log the \"No images found\" message for empty repositories at debug level only")
+	flag.Float64Var(&opts.rps, "rps", 20, "Maximum ECR API calls per second, shared by every region and worker; 0 disables the limit")
+	flag.IntVar(&opts.maxRetries, "max-retries", 5, "Maximum retries for throttled AWS calls and for images that fail to delete with a transient error")
+	flag.BoolVar(&opts.deleteUntagged, "delete-untagged", true, "Delete untagged images")
+	flag.StringVar(&opts.deleteEmptyRepos, "delete-empty-repositories", "", "Comma-separated glob patterns of repositories to delete once the run leaves them without images")
+	flag.BoolVar(&opts.deleteByTag, "delete-by-tag", false, "Delete the unprotected tags of expired protected images by tag, leaving the protected tags intact")
+	flag.BoolVar(&opts.followReferrers, "follow-referrers", false, "Delete signatures, SBOMs and other OCI referrers with the image they refer to, and keep them while it is kept (needs ecr:BatchGetImage)")
+	flag.BoolVar(&opts.skipLifecycle, "skip-lifecycle-managed", false, "Skip repositories that have an ECR lifecycle policy")
+	flag.BoolVar(&opts.scanFindings, "since-scan-findings", false, "Keep deletion candidates whose scan has findings at or above -min-severity")
+	flag.StringVar(&opts.minSeverity, "min-severity", ecr.FindingSeverityCritical, "Lowest scan finding severity that keeps an image with -since-scan-findings")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Cleans up old images from Amazon ECR. Prompts interactively when no flags are given.")
+		fmt.Fprintln(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NFlag() == 0 {
+		resolveRegion(&opts)
+		promptForOptions(&opts)
+		return opts, nil
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	if configPath != "" {
+		fc, err := loadConfigFile(configPath)
+		if err != nil {
+			return opts, fmt.Errorf("invalid config file: %w", err)
+		}
+		fc.apply(&opts, setFlags)
+	}
+	switch {
+	case setFlags["region"] || setFlags["regions"]:
+		opts.regionSource = "flag"
+	case opts.region != "" || opts.regionList != "":
+		opts.regionSource = "config file"
+	default:
+		resolveRegion(&opts)
+	}
 
-	var region string
-	var retention int
-	var prefixList string
+	// Deleting is opt-in: -confirm-delete, or an explicit -dry-run=false.
+	// A config file alone cannot turn deletion on.
+	if !opts.dryRun && !setFlags["dry-run"] && !opts.confirmDelete {
+		return opts, errors.New("dry-run: false in the config file requires -confirm-delete")
+	}
+	if opts.confirmDelete {
+		if setFlags["dry-run"] && opts.dryRun {
+			return opts, errors.New("-confirm-delete cannot be combined with -dry-run")
+		}
+		opts.dryRun = false
+	}
+
+	return opts, nil
+}
+
+// retentionText describes the retention window for the startup log.
+func (o options) retentionText() string {
+	if o.retentionDuration > 0 {
+		return o.retentionDuration.String()
+	}
+	return fmt.Sprintf("%d days", o.retention)
+}
+
+// promptForOptions asks the user for each setting on stdin.
+func promptForOptions(opts *options) {
 	var dryRunInput string
-	var dryRun bool
 
-	// Step 1: Ask user for inputs
-	fmt.Print("Enter AWS Region (e.g., us-east-1): ")
-	fmt.Scanln(&region)
+	if opts.region == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Print("Enter AWS Region (e.g., us-east-1): ")
+		fmt.Scanln(&opts.region)
+		opts.regionSource = "prompt"
+	}
 
 	fmt.Print("Enter retention period in days (e.g., 10): ")
-	fmt.Scanln(&retention)
+	fmt.Scanln(&opts.retention)
 
 	fmt.Print("Enter comma-separated tag prefixes to keep (e.g., latest,dev,main): ")
-	fmt.Scanln(&prefixList)
+	fmt.Scanln(&opts.prefixList)
 
-	fmt.Print("Dry-run mode? (yes/no): ")
+	fmt.Print("Dry-run mode? (YES/no): ")
 	fmt.Scanln(&dryRunInput)
-	dryRun = strings.ToLower(dryRunInput) == "yes"
+	opts.dryRun = strings.ToLower(dryRunInput) != "no"
+	opts.interactive = true
+}
+
+// confirmInteractiveDelete asks an interactive user, before a real run, to
+// type the number of repositories that will be cleaned up. Anything else
+// aborts the run.
+func confirmInteractiveDelete(ctx context.Context, opts options, regions []string) error {
+	total := 0
+	for _, region := range regions {
+		sess, err := newSession(opts, region)
+		if err != nil {
+			return fmt.Errorf("error creating AWS session: %w", err)
+		}
+		err = newECRClient(sess, clientConfig(sess, opts), opts).DescribeRepositoriesPagesWithContext(ctx, &ecr.DescribeRepositoriesInput{},
+			func(page *ecr.DescribeRepositoriesOutput, lastPage bool) bool {
+				total += len(page.Repositories)
+				return true
+			})
+		if err != nil {
+			return fmt.Errorf("failed to list repositories in %s: %w", region, err)
+		}
+	}
 
-	logger.Printf("[INFO] Starting ECR cleanup in region %s | Retention: %d days | Prefixes: %s | Dry-run: %v",
-		region, retention, prefixList, dryRun)
+	fmt.Printf("⚠️ Images will be DELETED from %d repositories in %s. Type %d to confirm: ", total, strings.Join(regions, ","), total)
+	var answer string
+	fmt.Scanln(&answer)
+	if strings.TrimSpace(answer) != strconv.Itoa(total) {
+		return errors.New("deletion not confirmed")
+	}
+	return nil
+}
 
-	// Step 2: Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
+// validate checks that the options describe a runnable cleanup.
+func (o options) validate() error {
+	if o.simulate != "" {
+		switch {
+		case o.discoverByTags || o.resourceTags != "":
+			return errors.New("simulate cannot be combined with resource-tag")
+		case o.followReferrers:
+			return errors.New("simulate cannot be combined with follow-referrers: the fixtures have no manifests")
+		case o.serveAddr != "" || o.listRepos || o.stats:
+			return errors.New("simulate cannot be combined with serve, list-repos or stats")
+		}
+	}
+	if len(o.regions()) == 0 && o.simulate == "" {
+		return errors.New("region must not be empty: set -region, AWS_REGION or a region in the AWS profile")
+	}
+	if o.externalID != "" && o.roleArn == "" {
+		return errors.New("external-id requires assume-role-arn")
+	}
+	if o.repoList != "" && o.resourceTags != "" {
+		return errors.New("repos cannot be combined with resource-tag")
+	}
+	if o.discoverByTags && o.resourceTags == "" {
+		return errors.New("discover-by-tags requires resource-tag")
+	}
+	if o.endpointURL != "" {
+		if u, err := url.Parse(o.endpointURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint-url must be an http or https URL, got %q", o.endpointURL)
+		}
+		// A custom endpoint serves, and signs requests for, one region
+		if len(o.regions()) > 1 {
+			return errors.New("endpoint-url requires a single region")
+		}
+		if o.useFIPS {
+			return errors.New("use-fips has no effect with endpoint-url; pass the FIPS endpoint as the URL instead")
+		}
+	}
+	if o.retention < 0 {
+		return fmt.Errorf("retention must be non-negative, got %d", o.retention)
+	}
+	if o.webIdentity {
+		if o.profile != "" {
+			return errors.New("web-identity cannot be combined with profile")
+		}
+		if os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") == "" || os.Getenv("AWS_ROLE_ARN") == "" {
+			return errors.New("web-identity requires AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN to be set")
+		}
+	}
+	if o.retentionDuration < 0 {
+		return fmt.Errorf("retention-duration must be non-negative, got %s", o.retentionDuration)
+	}
+	if o.gracePeriod < 0 {
+		return fmt.Errorf("grace-period must be non-negative, got %s", o.gracePeriod)
+	}
+	if o.untaggedRetention < 0 {
+		return fmt.Errorf("untagged-retention must be non-negative, got %d", o.untaggedRetention)
+	}
+	if o.unpulledDays < 0 {
+		return fmt.Errorf("unpulled-days must be non-negative, got %d", o.unpulledDays)
+	}
+	if o.minSizeMB < 0 {
+		return fmt.Errorf("min-size-mb must be non-negative, got %d", o.minSizeMB)
+	}
+	if o.rps < 0 {
+		return fmt.Errorf("rps must be non-negative, got %g", o.rps)
+	}
+	if o.matchMode != matchPrefix && o.matchMode != matchRegex {
+		return fmt.Errorf("match-mode must be %q or %q, got %q", matchPrefix, matchRegex, o.matchMode)
+	}
+	if o.sortOrder != cleaner.SortAsc && o.sortOrder != cleaner.SortDesc {
+		return fmt.Errorf("sort must be %q or %q, got %q", cleaner.SortAsc, cleaner.SortDesc, o.sortOrder)
+	}
+	if o.keepBy != cleaner.KeepByPushed && o.keepBy != cleaner.KeepBySemver && o.keepBy != cleaner.KeepByVersion {
+		return fmt.Errorf("keep-by must be %q, %q or %q, got %q", cleaner.KeepByPushed, cleaner.KeepBySemver, cleaner.KeepByVersion, o.keepBy)
+	}
+	if o.versionRegex != "" && o.keepBy != cleaner.KeepByVersion {
+		return fmt.Errorf("version-regex requires keep-by %s", cleaner.KeepByVersion)
+	}
+	if o.scanFindings && !slices.Contains(ecr.FindingSeverity_Values(), strings.ToUpper(o.minSeverity)) {
+		return fmt.Errorf("min-severity must be one of %s, got %q", strings.Join(ecr.FindingSeverity_Values(), ", "), o.minSeverity)
+	}
+	if o.cutoffMode != cleaner.CutoffAnd && o.cutoffMode != cleaner.CutoffOr {
+		return fmt.Errorf("cutoff-mode must be %q or %q, got %q", cleaner.CutoffAnd, cleaner.CutoffOr, o.cutoffMode)
+	}
+	if o.output != outputText && o.output != outputJSON && o.output != outputTable {
+		return fmt.Errorf("output must be %q, %q or %q, got %q", outputText, outputJSON, outputTable, o.output)
+	}
+	if o.verbose && (o.output != outputTable || o.quiet) {
+		return fmt.Errorf("verbose requires output %s and cannot be combined with quiet", outputTable)
+	}
+	if o.logFormat != logging.FormatText && o.logFormat != logging.FormatJSON {
+		return fmt.Errorf("log-format must be %q or %q, got %q", logging.FormatText, logging.FormatJSON, o.logFormat)
+	}
+	if _, err := logging.ParseLevel(o.logLevel); err != nil {
+		return err
+	}
+	if o.minRepos < 0 {
+		return fmt.Errorf("min-repos must be non-negative, got %d", o.minRepos)
+	}
+	if o.maxDelete < 0 || o.maxDeleteRepo < 0 {
+		return errors.New("max-delete and max-delete-per-repo must be non-negative")
+	}
+	if o.maxReclaimMB < 0 {
+		return fmt.Errorf("max-reclaim-mb must be non-negative, got %d", o.maxReclaimMB)
+	}
+	if o.untaggedFirst && o.maxDelete == 0 && o.maxReclaimMB == 0 {
+		return errors.New("untagged-first requires max-delete or max-reclaim-mb")
+	}
+	if o.pushgateway != "" {
+		if u, err := url.Parse(o.pushgateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("pushgateway-url must be an http or https URL, got %q", o.pushgateway)
+		}
+	}
+	if o.webhookURL != "" {
+		if u, err := url.Parse(o.webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook-url must be an http or https URL, got %q", o.webhookURL)
+		}
+	}
+	if len(o.webhookHeaders) > 0 && o.webhookURL == "" {
+		return errors.New("webhook-header requires webhook-url")
+	}
+	for _, header := range o.webhookHeaders {
+		if name, _, ok := strings.Cut(header, ":"); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("webhook-header must be \"Name: value\", got %q", header)
+		}
+	}
+	if o.webhookTimeout <= 0 {
+		return fmt.Errorf("webhook-timeout must be positive, got %s", o.webhookTimeout)
+	}
+	if o.timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %s", o.timeout)
+	}
+	if o.progress < 0 {
+		return fmt.Errorf("progress-interval must be non-negative, got %s", o.progress)
+	}
+	if o.concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1, got %d", o.concurrency)
+	}
+	if o.deleteWorkers < 1 {
+		return fmt.Errorf("delete-concurrency must be at least 1, got %d", o.deleteWorkers)
+	}
+	// Prompts from concurrent regions would interleave on one terminal
+	if o.confirmEach && o.parallelRegions {
+		return errors.New("confirm-each cannot be combined with parallel-regions")
+	}
+	// A service has no operator at a terminal, and resumes nothing
+	if o.serveAddr != "" && (o.confirmEach || o.stateFile != "") {
+		return errors.New("serve cannot be combined with confirm-each or state-file")
+	}
+	if (o.listRepos || o.stats) && o.serveAddr != "" {
+		return errors.New("list-repos and stats cannot be combined with serve")
+	}
+	if o.since != "" && o.since != sinceLastRun {
+		return fmt.Errorf("since must be %q, got %q", sinceLastRun, o.since)
+	}
+	if o.since != "" && o.stateFile == "" {
+		return errors.New("since requires state-file")
+	}
+	if o.fullScanAfter <= 0 {
+		return fmt.Errorf("full-scan-after must be positive, got %s", o.fullScanAfter)
+	}
+	if o.listRepos && o.stats {
+		return errors.New("list-repos and stats cannot be combined")
+	}
+	if o.maxRetries < 0 {
+		return fmt.Errorf("max-retries must be non-negative, got %d", o.maxRetries)
+	}
+	if o.groupDepth < 0 {
+		return fmt.Errorf("group-depth must be non-negative, got %d", o.groupDepth)
+	}
+	if o.logMaxSizeMB < 0 {
+		return fmt.Errorf("log-max-size-mb must be non-negative, got %d", o.logMaxSizeMB)
+	}
+	if o.keep < 1 {
+		return fmt.Errorf("keep must be at least 1, got %d", o.keep)
+	}
+	if o.minKeep < 0 {
+		return fmt.Errorf("min-keep must be non-negative, got %d", o.minKeep)
+	}
+	if o.tagCountThreshold < 0 {
+		return fmt.Errorf("tag-count-threshold must be non-negative, got %d", o.tagCountThreshold)
+	}
+	return nil
+}
+
+// regions returns the regions to clean up: -region followed by the
+// entries of -regions, without duplicates.
+func (o options) regions() []string {
+	var regions []string
+	seen := make(map[string]bool)
+	for _, region := range append(splitList(o.region), splitList(o.regionList)...) {
+		if !seen[region] {
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// parse derives the cleanup policy from the raw flag values.
+func (o *options) parse() error {
+	if o.simulate != "" {
+		sim, err := loadSimulation(o.simulate)
+		if err != nil {
+			return fmt.Errorf("invalid simulate file: %w", err)
+		}
+		o.simulation = sim
+		o.planOnly = true
+		if len(o.regions()) == 0 {
+			o.region = simulatedRegion
+		}
+	}
+	if o.planOnly {
+		o.dryRun = true
+	}
+	if o.reportOnly {
+		o.dryRun = true
+		o.quiet = true
+	}
+	// The table replaces the per-image lines for a human reader
+	if o.output == outputTable && !o.verbose {
+		o.quiet = true
+	}
+	if o.explain && !o.dryRun {
+		return errors.New("explain requires a dry run")
+	}
+	var lifecyclePolicy *cleaner.LifecyclePolicy
+	if o.lifecycleFile != "" {
+		if !o.dryRun || o.reportOnly {
+			return errors.New("compare-lifecycle requires a dry run without report-only")
+		}
+		data, err := os.ReadFile(o.lifecycleFile)
+		if err != nil {
+			return fmt.Errorf("invalid compare-lifecycle file: %w", err)
+		}
+		lifecyclePolicy, err = cleaner.ParseLifecyclePolicy(data)
+		if err != nil {
+			return fmt.Errorf("invalid compare-lifecycle file %s: %w", o.lifecycleFile, err)
+		}
+	}
+	// Prompts from parallel workers would interleave
+	if o.confirmEach && !o.dryRun {
+		o.concurrency = 1
+	}
+
+	p := cleaner.Config{
+		Retention:            o.retention,
+		RetentionDuration:    o.retentionDuration,
+		UntaggedRetention:    o.untaggedRetention,
+		MinSize:              int64(o.minSizeMB) * 1024 * 1024,
+		UnpulledDays:         o.unpulledDays,
+		GracePeriod:          o.gracePeriod,
+		CutoffMode:           o.cutoffMode,
+		Keep:                 o.keep,
+		KeepBy:               o.keepBy,
+		SortOrder:            o.sortOrder,
+		IncludeUnmatched:     o.includeUnmatched,
+		MinKeep:              o.minKeep,
+		TagCountThreshold:    o.tagCountThreshold,
+		ProtectTags:          make(map[string]bool),
+		ExcludePrefixes:      splitList(o.excludePrefixes),
+		Repositories:         splitList(o.repoList),
+		RepoFilter:           splitList(o.repoFilter),
+		DeleteEmptyRepos:     splitList(o.deleteEmptyRepos),
+		RepoExclude:          splitList(o.repoExclude),
+		DeleteUntagged:       o.deleteUntagged,
+		MaxDeletePerRepo:     o.maxDeleteRepo,
+		MinRepos:             o.minRepos,
+		DeleteByTag:          o.deleteByTag,
+		Incremental:          o.since == sinceLastRun,
+		FullScanAfter:        o.fullScanAfter,
+		FollowReferrers:      o.followReferrers,
+		SkipLifecycleManaged: o.skipLifecycle,
+		DryRun:               o.dryRun,
+		Concurrency:          o.concurrency,
+		DeleteConcurrency:    o.deleteWorkers,
+		MaxRetries:           o.maxRetries,
+		FailFast:             o.failFast,
+		ProgressInterval:     o.progress,
+		Quiet:                o.quiet,
+		QuietEmptyRepos:      o.quietEmpty,
+		ReportOnly:           o.reportOnly,
+		Explain:              o.explain,
+		CompareLifecycle:     lifecyclePolicy,
+	}
+
+	if o.scanFindings {
+		p.MinSeverity = strings.ToUpper(o.minSeverity)
+	}
+
+	keepMap, err := parseKeepMap(o.keepList)
+	if err != nil {
+		return fmt.Errorf("invalid keep-map: %w", err)
+	}
+	p.KeepMap = keepMap
+	keepByRepo, err := parseKeepMap(o.keepRepoList)
+	if err != nil {
+		return fmt.Errorf("invalid keep-by-repo: %w", err)
+	}
+	p.KeepByRepo = keepByRepo
+	if o.versionRegex != "" {
+		p.VersionPattern, err = regexp.Compile(o.versionRegex)
+		if err != nil {
+			return fmt.Errorf("invalid version-regex: %w", err)
+		}
+	}
+	if o.ageFromTagRegex != "" {
+		p.AgeFromTag, err = regexp.Compile(o.ageFromTagRegex)
+		if err != nil {
+			return fmt.Errorf("invalid age-from-tag-regex: %w", err)
+		}
+	}
+	graceMap, err := parseGraceMap(o.graceList)
 	if err != nil {
-		logger.Fatalf("[ERROR] Error creating AWS session: %v", err)
+		return fmt.Errorf("invalid grace-map: %w", err)
 	}
+	p.GraceMap = graceMap
 
-	// Step 3: Create ECR client
-	svc := ecr.New(sess)
+	if o.beforeDate != "" {
+		before, err := parseDate(o.beforeDate)
+		if err != nil {
+			return fmt.Errorf("invalid before date: %w", err)
+		}
+		p.Before = before
+	}
 
-	// Step 4: List repositories
-	repos, err := svc.DescribeRepositories(&ecr.DescribeRepositoriesInput{})
+	patterns := strings.Split(o.prefixList, ",")
+	if o.prefixesFile != "" {
+		lines, err := readListFile(o.prefixesFile)
+		if err != nil {
+			return fmt.Errorf("invalid prefixes-file: %w", err)
+		}
+		if o.prefixList == "" {
+			patterns = nil
+		}
+		for _, line := range lines {
+			if !slices.Contains(patterns, line) {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+	if o.policyFile != "" {
+		rules, err := cleaner.LoadPolicy(o.policyFile)
+		if err != nil {
+			return fmt.Errorf("invalid policy-file: %w", err)
+		}
+		if o.prefixList == "" {
+			patterns = slices.DeleteFunc(patterns, func(pattern string) bool { return pattern == "" })
+		}
+		p.Rules = make(map[string]cleaner.Rule)
+		for _, rule := range rules.Rules {
+			p.Rules[rule.Prefix] = rule
+			if !slices.Contains(patterns, rule.Prefix) {
+				patterns = append(patterns, rule.Prefix)
+			}
+		}
+		p.DefaultRule = rules.Default
+	}
+	for _, pattern := range patterns {
+		matcher := cleaner.PrefixMatcher(pattern)
+		if o.matchMode == matchRegex {
+			matcher, err = cleaner.RegexMatcher(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid tag regex %q: %w", pattern, err)
+			}
+		}
+		p.Matchers = append(p.Matchers, matcher)
+	}
+	if o.matcherFile != "" {
+		matchers, err := cleaner.LoadMatcherFile(o.matcherFile)
+		if err != nil {
+			return fmt.Errorf("invalid tag-allow-regex-file: %w", err)
+		}
+		if o.prefixList == "" {
+			p.Matchers = slices.DeleteFunc(p.Matchers, func(m cleaner.TagMatcher) bool { return m.Pattern == "" })
+		}
+		for _, matcher := range matchers {
+			if !slices.ContainsFunc(p.Matchers, func(m cleaner.TagMatcher) bool { return m.Pattern == matcher.Pattern }) {
+				p.Matchers = append(p.Matchers, matcher)
+			}
+		}
+	}
+
+	for _, tag := range splitList(o.protectList) {
+		p.ProtectTags[tag] = true
+	}
+	digests := splitList(o.protectDigests)
+	if o.protectDigestsFile != "" {
+		lines, err := readListFile(o.protectDigestsFile)
+		if err != nil {
+			return fmt.Errorf("invalid protect-digests-file: %w", err)
+		}
+		digests = append(digests, lines...)
+	}
+	if len(digests) > 0 {
+		p.ProtectDigests = make(map[string]bool)
+		for _, digest := range digests {
+			if !strings.HasPrefix(digest, "sha256:") {
+				return fmt.Errorf("invalid protected digest %q: must start with sha256:", digest)
+			}
+			p.ProtectDigests[digest] = true
+		}
+	}
+	if types := splitList(o.mediaTypes); len(types) > 0 {
+		p.MediaTypes = make(map[string]bool)
+		for _, mediaType := range types {
+			p.MediaTypes[mediaType] = true
+		}
+	}
+
+	resourceTags, err := parseResourceTags(o.resourceTags)
 	if err != nil {
-		logger.Fatalf("[ERROR] Failed to list repositories: %v", err)
+		return fmt.Errorf("invalid resource-tag: %w", err)
+	}
+	p.ResourceTags = resourceTags
+
+	if o.protectReposFile != "" {
+		names, err := readListFile(o.protectReposFile)
+		if err != nil {
+			return fmt.Errorf("invalid protect-repos-file: %w", err)
+		}
+		p.ProtectRepos = make(map[string]bool)
+		for _, name := range names {
+			p.ProtectRepos[name] = true
+		}
+	}
+
+	for _, pattern := range append(append(p.RepoFilter, p.RepoExclude...), p.DeleteEmptyRepos...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q: %w", pattern, err)
+		}
+	}
+
+	if o.rps > 0 {
+		o.limiter = rate.NewLimiter(rate.Limit(o.rps), int(math.Ceil(o.rps)))
+	}
+
+	o.policy = p
+	return nil
+}
+
+// splitList splits a comma-separated list, trimming whitespace and
+// dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readListFile reads a file with one entry per line, trimming whitespace
+// and skipping blank lines and lines starting with #.
+func readListFile(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+// parseDate accepts either an RFC3339 timestamp or a plain YYYY-MM-DD
+// date, which is taken as midnight UTC.
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC3339 timestamp or YYYY-MM-DD date", value)
+	}
+	return t, nil
+}
+
+// parseKeepMap parses a list of name=count pairs, prefixes for -keep-map
+// and repositories for -keep-by-repo, such as "prod=10,dev=2" into a map.
+func parseKeepMap(list string) (map[string]int, error) {
+	keepMap := make(map[string]int)
+	if list == "" {
+		return keepMap, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		prefix, count, ok := strings.Cut(entry, "=")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("entry %q must be in the form name=count", entry)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q has a non-numeric count", entry)
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("entry %q must keep at least 1 image", entry)
+		}
+		if _, ok := keepMap[prefix]; ok {
+			return nil, fmt.Errorf("entry %q repeats %s", entry, prefix)
+		}
+		keepMap[prefix] = n
+	}
+	return keepMap, nil
+}
+
+// parseGraceMap parses a list of prefix=duration pairs such as
+// "release-=168h,hotfix-=0" into a map.
+func parseGraceMap(list string) (map[string]time.Duration, error) {
+	graceMap := make(map[string]time.Duration)
+	for _, entry := range splitList(list) {
+		prefix, value, ok := strings.Cut(entry, "=")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("entry %q must be in the form prefix=duration", entry)
+		}
+		grace, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q has an invalid duration: %w", entry, err)
+		}
+		if grace < 0 {
+			return nil, fmt.Errorf("entry %q has a negative duration", entry)
+		}
+		if _, ok := graceMap[prefix]; ok {
+			return nil, fmt.Errorf("entry %q repeats %s", entry, prefix)
+		}
+		graceMap[prefix] = grace
 	}
+	return graceMap, nil
+}
 
-	if len(repos.Repositories) == 0 {
-		logger.Println("[WARNING] No repositories found in the specified region.")
+// parseResourceTags parses a list of key=value pairs such as
+// "Environment=dev,Team=web" into a map.
+func parseResourceTags(list string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, entry := range splitList(list) {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("entry %q must be in the form key=value", entry)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// resolveRegion fills in the region when none was given, the way the AWS
+// SDK does: from AWS_REGION, then AWS_DEFAULT_REGION, then the region of
+// the AWS profile. The region stays empty when none of them has one.
+func resolveRegion(opts *options) {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			opts.region = region
+			opts.regionSource = "env " + name
+			return
+		}
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           opts.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil || aws.StringValue(sess.Config.Region) == "" {
 		return
 	}
+	opts.region = aws.StringValue(sess.Config.Region)
+	opts.regionSource = "profile"
+}
+
+// newSession creates an AWS session for the given region, using the named
+// profile when one is set. Throttled and other retryable errors are
+// retried with exponential backoff; non-retryable errors fail immediately.
+// A profile, or -shared-config, loads ~/.aws/config, which is where SSO and
+// credential_process profiles are defined.
+func newSession(opts options, region string) (*session.Session, error) {
+	config := aws.Config{
+		Region: aws.String(region),
+	}
+	request.WithRetryer(&config, client.DefaultRetryer{NumMaxRetries: opts.maxRetries})
+	if opts.useFIPS {
+		config.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	sharedConfig := session.SharedConfigStateFromEnv
+	if opts.profile != "" || opts.sharedConfig {
+		sharedConfig = session.SharedConfigEnable
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           opts.profile,
+		Config:            config,
+		SharedConfigState: sharedConfig,
+	})
+	if err != nil || !opts.webIdentity {
+		return sess, err
+	}
+	// The default chain prefers static keys in the environment over the
+	// web identity token, so -web-identity sets the provider explicitly
+	sess.Config.Credentials = stscreds.NewWebIdentityCredentials(sess,
+		os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_ROLE_SESSION_NAME"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	return sess, nil
+}
 
-	prefixes := strings.Split(prefixList, ",")
+// checkCredentials resolves the credentials the run will use in the region,
+// assuming -assume-role-arn if set, so that a missing or expired login
+// fails before any work is done. It returns the name of the provider that
+// supplied them.
+func checkCredentials(opts options, region string) (string, error) {
+	sess, err := newSession(opts, region)
+	if err != nil {
+		return "", err
+	}
+	creds := clientConfig(sess, opts).Credentials
+	if creds == nil {
+		creds = sess.Config.Credentials
+	}
+	value, err := creds.Get()
+	return value.ProviderName, err
+}
 
-	// Define the taggedImage struct
-	type taggedImage struct {
-		digest     string
-		tags       []*string
-		pushedTime time.Time
+// clientConfig returns the configuration shared by every AWS client the
+// tool creates, carrying assumed-role credentials when a role is set.
+func clientConfig(sess *session.Session, opts options) *aws.Config {
+	if opts.roleArn == "" {
+		return &aws.Config{}
 	}
+	creds := stscreds.NewCredentials(sess, opts.roleArn, func(p *stscreds.AssumeRoleProvider) {
+		if opts.externalID != "" {
+			p.ExternalID = aws.String(opts.externalID)
+		}
+	})
+	return &aws.Config{Credentials: creds}
+}
 
-	// Step 5: Loop through each repository
-	for _, repo := range repos.Repositories {
-		repoName := *repo.RepositoryName
-		logger.Printf("\n[INFO] 📦 Processing Repository: %s", repoName)
+// ecrConfig returns the configuration specific to ECR clients, pointing
+// them at -endpoint-url when it is set.
+func ecrConfig(opts options) *aws.Config {
+	if opts.endpointURL == "" {
+		return &aws.Config{}
+	}
+	return &aws.Config{Endpoint: aws.String(opts.endpointURL)}
+}
 
-		// Step 6: Get all images in the repository
-		imageOutput, err := svc.DescribeImages(&ecr.DescribeImagesInput{
-			RepositoryName: aws.String(repoName),
+// newECRClient creates an ECR client whose calls, retries included, each
+// wait for a token from the -rps limiter.
+func newECRClient(sess *session.Session, awsConfig *aws.Config, opts options) *ecr.ECR {
+	svc := ecr.New(sess, awsConfig, ecrConfig(opts))
+	if opts.limiter != nil {
+		svc.Handlers.Sign.PushFront(func(r *request.Request) {
+			if err := opts.limiter.Wait(r.Context()); err != nil {
+				r.Error = err
+			}
 		})
-		if err != nil {
-			logger.Printf("[WARNING] Failed to describe images for %s: %v", repoName, err)
-			continue
+	}
+	return svc
+}
+
+// logTotals reports the image totals of a summary under a label.
+func logTotals(opts options, label string, summary cleaner.RunSummary) {
+	verb := "Deleted"
+	reclaimVerb := "Reclaimed"
+	if opts.dryRun {
+		verb = "Would delete"
+		reclaimVerb = "Would reclaim"
+	}
+	logger.Summaryf("%s: %s %d untagged and %d old images | Retained: %d | Failed: %d | %s %s",
+		label, verb, summary.Totals.UntaggedDeleted, summary.Totals.Deleted-summary.Totals.UntaggedDeleted,
+		summary.Totals.Retained, summary.Totals.Failed, reclaimVerb, cleaner.FormatBytes(summary.Totals.ReclaimedBytes))
+	if opts.deleteByTag {
+		logger.Summaryf("%s: %s %d tags from protected images", label, verb, summary.Totals.TagsDeleted)
+	}
+}
+
+// printSummary reports the run totals, writing the full summary document
+// to stdout in JSON output mode.
+func printSummary(opts options, summary cleaner.RunSummary) {
+	logTotals(opts, "Total", summary)
+	if opts.groupDepth > 0 {
+		summary.Groups = summary.GroupBy(opts.groupDepth)
+		logGroups(opts, summary.Groups)
+	}
+	if len(summary.Errors) > 0 {
+		logRepoErrors(summary.Errors)
+	}
+	if opts.lifecycleFile != "" {
+		logLifecycleComparison(summary)
+	}
+	if opts.output == outputTable {
+		logRepoTable(opts, summary)
+	}
+
+	if opts.output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summary); err != nil {
+			logger.Errorf("Failed to write JSON summary: %v", err)
 		}
+	}
+}
 
-		if len(imageOutput.ImageDetails) == 0 {
-			logger.Printf("[INFO] No images found in repository %s", repoName)
+// logLifecycleComparison logs the reconciliation of the plans with the
+// -compare-lifecycle policy across the run, and the repositories on which
+// they diverge.
+func logLifecycleComparison(summary cleaner.RunSummary) {
+	var agreedDeletes, agreedKeeps, toolOnly, policyOnly int
+	for _, repo := range summary.Repositories {
+		if repo.Lifecycle == nil {
 			continue
 		}
+		agreedDeletes += repo.Lifecycle.AgreedDeletes
+		agreedKeeps += repo.Lifecycle.AgreedKeeps
+		toolOnly += len(repo.Lifecycle.ToolOnly)
+		policyOnly += len(repo.Lifecycle.PolicyOnly)
+	}
+	logger.Summaryf("⚖️ Lifecycle policy reconciliation: %d images agree (%d deleted, %d kept), %d deleted only by this tool, %d expired only by the policy",
+		agreedDeletes+agreedKeeps, agreedDeletes, agreedKeeps, toolOnly, policyOnly)
+	if toolOnly+policyOnly == 0 {
+		logger.Summaryf("  ✅ The policy makes the same decision for every image")
+		return
+	}
+	for _, repo := range summary.Repositories {
+		if repo.Lifecycle != nil && len(repo.Lifecycle.ToolOnly)+len(repo.Lifecycle.PolicyOnly) > 0 {
+			logger.Summaryf("  %s/%s: %d deleted only by this tool, %d expired only by the policy",
+				repo.Region, repo.Repository, len(repo.Lifecycle.ToolOnly), len(repo.Lifecycle.PolicyOnly))
+		}
+	}
+}
 
-		// Step 7: Group images by prefix
-		prefixMatchMap := make(map[string][]taggedImage)
+// logRepoErrors lists the repositories that failed and why, so that they
+// can be re-run with -repos.
+func logRepoErrors(errs []cleaner.RepoError) {
+	logger.Summaryf("❌ Repositories with errors:")
+	for _, repoErr := range errs {
+		logger.Summaryf("  %s/%s: %s", repoErr.Region, repoErr.Repository, repoErr.Error)
+	}
+}
 
-		for _, image := range imageOutput.ImageDetails {
-			if image.ImagePushedAt == nil || len(image.ImageTags) == 0 {
-				continue
-			}
-			for _, tag := range image.ImageTags {
-				for _, prefix := range prefixes {
-					if strings.HasPrefix(*tag, prefix) {
-						prefixMatchMap[prefix] = append(prefixMatchMap[prefix], taggedImage{
-							digest:     *image.ImageDigest,
-							tags:       image.ImageTags,
-							pushedTime: *image.ImagePushedAt,
-						})
-						break
-					}
-				}
-			}
+// logGroups logs a table of the per-group totals.
+func logGroups(opts options, groups []cleaner.GroupSummary) {
+	deleted := "Deleted"
+	if opts.dryRun {
+		deleted = "Would delete"
+	}
+	logger.Summaryf("📊 Summary by repository group:")
+	logger.Summaryf("  %-30s %6s %8s %8s %12s %12s", "Group", "Repos", "Scanned", "Kept", deleted, "Reclaimed")
+	for _, group := range groups {
+		logger.Summaryf("  %-30s %6d %8d %8d %12d %12s", group.Group, group.Repositories,
+			group.Scanned, group.Retained, group.Deleted, cleaner.FormatBytes(group.ReclaimedBytes))
+	}
+}
+
+// logRepoTable logs an aligned table of the image counts of each
+// repository, followed by the totals.
+func logRepoTable(opts options, summary cleaner.RunSummary) {
+	deleted, reclaimed := "DELETED", "RECLAIMED"
+	if opts.dryRun {
+		deleted, reclaimed = "WOULD DELETE", "WOULD RECLAIM"
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "REPOSITORY\tSCANNED\tKEPT\t%s\tFAILED\t%s\n", deleted, reclaimed)
+	row := func(name string, counts cleaner.ImageCounts) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", name, counts.Scanned, counts.Retained, counts.Deleted,
+			counts.Failed, cleaner.FormatBytes(counts.ReclaimedBytes))
+	}
+	for _, repo := range summary.Repositories {
+		row(repo.Region+"/"+repo.Repository, repo.ImageCounts)
+	}
+	row(fmt.Sprintf("Total (%d repositories)", len(summary.Repositories)), summary.Totals)
+	w.Flush()
+	logger.Summaryf("📊 Repository summary:")
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		logger.Summaryf("%s", line)
+	}
+}
+
+// printReclaimable logs, per repository and in total, the images and bytes
+// the current settings would delete.
+func printReclaimable(summary cleaner.RunSummary) {
+	logger.Summaryf("📊 Reclaimable space by repository:")
+	for _, repo := range summary.Repositories {
+		if repo.Deleted > 0 {
+			logger.Summaryf("  %s/%s: %d images, %s", repo.Region, repo.Repository, repo.Deleted, cleaner.FormatBytes(repo.ReclaimedBytes))
 		}
+	}
+	logger.Summaryf("  Total: %d images, %s", summary.Totals.Deleted, cleaner.FormatBytes(summary.Totals.ReclaimedBytes))
+}
 
-		// Step 8: Build a set of digests to retain (top 2 per prefix)
-		retainedDigests := make(map[string]bool)
-		for _, images := range prefixMatchMap {
-			sort.Slice(images, func(i, j int) bool {
-				return images[i].pushedTime.After(images[j].pushedTime)
-			})
+// checkDeleteCap counts, with a silent dry run over every region, the images
+// the run would delete. It reports an error, after logging what would have
+// been deleted, if the total exceeds -max-delete. With -untagged-first, or
+// when the images would reclaim more than -max-reclaim-mb, it limits the
+// run to the candidates that fit within the caps instead.
+func checkDeleteCap(ctx context.Context, opts *options, regions []string) error {
+	dryOpts := *opts
+	dryOpts.policy.DryRun = true
+	dryOpts.policy.ReportOnly = false
+	dryOpts.planOnly = true
+	var total cleaner.RunSummary
+	for _, region := range regions {
+		summary, err := runRegion(ctx, dryOpts, region, nil, logging.Discard())
+		if err != nil {
+			return fmt.Errorf("region %s: %w", region, err)
+		}
+		total.Merge(summary)
+	}
 
-			for i := 0; i < len(images) && i < 2; i++ {
-				retainedDigests[images[i].digest] = true
+	maxBytes := int64(opts.maxReclaimMB) * 1024 * 1024
+	overCount := opts.maxDelete > 0 && total.Totals.Deleted > opts.maxDelete
+	overBytes := maxBytes > 0 && total.Totals.ReclaimedBytes > maxBytes
+	if opts.maxDelete > 0 {
+		logger.Infof("🔢 %d images would be deleted (limit %d from -max-delete)", total.Totals.Deleted, opts.maxDelete)
+	}
+	if maxBytes > 0 {
+		logger.Infof("🔢 %s would be reclaimed (limit %s from -max-reclaim-mb)",
+			cleaner.FormatBytes(total.Totals.ReclaimedBytes), cleaner.FormatBytes(maxBytes))
+	}
+	if !overCount && !overBytes {
+		return nil
+	}
+	if overCount && !opts.untaggedFirst {
+		for _, repo := range total.Repositories {
+			if repo.Deleted > 0 {
+				logger.Infof("  %s/%s: %d images (%s)", repo.Region, repo.Repository, repo.Deleted, cleaner.FormatBytes(repo.ReclaimedBytes))
 			}
 		}
+		return fmt.Errorf("%d images slated for deletion exceeds -max-delete %d", total.Totals.Deleted, opts.maxDelete)
+	}
 
-		// Step 9: Process each image
-		for _, image := range imageOutput.ImageDetails {
-			if image.ImagePushedAt == nil {
-				continue
-			}
-			imageAge := int(time.Since(*image.ImagePushedAt).Hours() / 24)
+	// Without -untagged-first the largest images go first, to free as
+	// much space as the byte cap allows
+	candidates := total.Candidates()
+	if !opts.untaggedFirst {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].SizeBytes > candidates[j].SizeBytes
+		})
+	}
+	opts.policy.DeleteOnly = make(map[string]bool)
+	var deleteBytes int64
+	for _, candidate := range candidates {
+		if opts.maxDelete > 0 && len(opts.policy.DeleteOnly) == opts.maxDelete {
+			break
+		}
+		if maxBytes > 0 && deleteBytes+candidate.SizeBytes > maxBytes {
+			continue
+		}
+		opts.policy.DeleteOnly[candidate.Key()] = true
+		deleteBytes += candidate.SizeBytes
+	}
+	logger.Warnf("⚠️ Deleting only %d of %d images (%s of %s) to stay within the caps; %d images (%s) are left for the next run",
+		len(opts.policy.DeleteOnly), len(candidates), cleaner.FormatBytes(deleteBytes), cleaner.FormatBytes(total.Totals.ReclaimedBytes),
+		len(candidates)-len(opts.policy.DeleteOnly), cleaner.FormatBytes(total.Totals.ReclaimedBytes-deleteBytes))
+	return nil
+}
 
-			// Untagged images
-			if len(image.ImageTags) == 0 {
-				logger.Printf("[DELETE] 🗑️ Untagged image candidate: %s", *image.ImageDigest)
-				continue
+// stopReason describes why the run context ended.
+func stopReason(err error, timeout time.Duration) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Sprintf("timed out after %s", timeout)
+	}
+	return "interrupted"
+}
+
+// runRegion cleans up the repositories of a single region with its own
+// session and ECR client.
+func runRegion(ctx context.Context, opts options, region string, report *cleaner.ReportWriter, log *logging.Logger) (cleaner.RunSummary, error) {
+	var svc cleaner.ECRAPI
+	var sess *session.Session
+	var awsConfig *aws.Config
+	if opts.simulation != nil {
+		svc = opts.simulation
+		log.Infof("🧪 Simulating ECR with %d images in %d repositories from %s",
+			opts.simulation.imageCount(), len(opts.simulation.names), opts.simulate)
+	} else {
+		// Step 2: Create AWS session
+		var err error
+		sess, err = newSession(opts, region)
+		if err != nil {
+			return cleaner.RunSummary{}, fmt.Errorf("error creating AWS session: %w", err)
+		}
+
+		// Step 3: Create ECR client
+		awsConfig = clientConfig(sess, opts)
+		client := newECRClient(sess, awsConfig, opts)
+		log.Infof("ECR endpoint: %s", client.Endpoint)
+		svc = client
+	}
+
+	// Step 4: Clean up the repositories
+	c := cleaner.New(svc, opts.policy)
+	c.Logger = log
+	c.Report = report
+	c.Region = region
+	c.State = opts.state
+	if opts.discoverByTags {
+		c.Tagging = resourcegroupstaggingapi.New(sess, awsConfig)
+	}
+	if opts.confirmEach {
+		c.Prompter = opts.prompter
+	}
+	summary, err := c.Run(ctx)
+	if err != nil {
+		return summary, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	if opts.emitMetrics && !opts.planOnly && !opts.reportOnly {
+		emitMetrics(cloudwatch.New(sess, awsConfig), region, summary)
+	}
+	return summary, nil
+}
+
+// runRegionsParallel cleans up the regions concurrently, at most
+// -concurrency at a time, each with its own clients and a logger that
+// prefixes its entries with the region. A region that fails is recorded in
+// the summary without stopping the others.
+func runRegionsParallel(ctx context.Context, opts options, regions []string, report *cleaner.ReportWriter, summary cleaner.RunSummary) (cleaner.RunSummary, []cleaner.RunSummary) {
+	var mu sync.Mutex
+	regionSummaries := make([]cleaner.RunSummary, len(regions))
+	done := make([]bool, len(regions))
+	slots := make(chan struct{}, max(opts.concurrency, 1))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+			log := logger.WithRegion(region)
+			log.Infof("==================== 🌍 Region: %s ====================", region)
+			regionSummary, err := runRegion(ctx, opts, region, report, log)
+			if err != nil && ctx.Err() != nil {
+				return
 			}
 
-			// Retained?
-			if retainedDigests[*image.ImageDigest] {
-				logger.Printf("[KEEP] ✅ Image retained (latest tag-match): %s | Tags: %v", *image.ImageDigest, image.ImageTags)
-				continue
+			mu.Lock()
+			defer mu.Unlock()
+			done[i] = true
+			if err != nil {
+				log.Errorf("Region %s failed: %v", region, err)
+				regionSummaries[i] = cleaner.RunSummary{Regions: []string{region}, FailedRegions: []string{region}}
+				return
 			}
+			logTotals(opts, "Region "+region, regionSummary)
+			regionSummaries[i] = regionSummary
+		}()
+	}
+	wg.Wait()
+
+	// Merged in the order of the regions, so the summary does not depend
+	// on which region finished first
+	var finished []cleaner.RunSummary
+	for i, regionSummary := range regionSummaries {
+		if !done[i] {
+			continue
+		}
+		if len(regionSummary.FailedRegions) > 0 {
+			summary.FailedRegions = append(summary.FailedRegions, regions[i])
+		} else {
+			summary.Merge(regionSummary)
+		}
+		finished = append(finished, regionSummary)
+	}
+	return summary, finished
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run performs the whole run and returns the exit status. main exits only
+// once run has returned, so the deferred cleanup, such as closing the
+// report and stopping the pprof server, always happens.
+func run() int {
+	startTime := time.Now()
+
+	// Step 1: Read flags, or ask user for inputs
+	opts, err := parseFlags()
+	setupLogger(opts)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return 1
+	}
+	if err := opts.validate(); err != nil {
+		logger.Errorf("Invalid options: %v", err)
+		return 1
+	}
+	if err := opts.parse(); err != nil {
+		logger.Errorf("Invalid options: %v", err)
+		return 1
+	}
+
+	regions := opts.regions()
+	logger.Infof("Starting ECR cleanup in regions %s | Retention: %s | Keep: %d | Prefixes: %s (%s) | Dry-run: %v",
+		strings.Join(regions, ","), opts.retentionText(), opts.keep, opts.prefixList, opts.matchMode, opts.dryRun)
+	if opts.deleteUntagged {
+		logger.Infof("Untagged retention: %d days", opts.untaggedRetention)
+	}
+	if opts.unpulledDays > 0 {
+		logger.Infof("Pulled images expire %d days after their last pull", opts.unpulledDays)
+	}
+	if opts.ageFromTagRegex != "" {
+		logger.Infof("Tagged images are aged by the date %s reads from their tags, or else by their push time", opts.ageFromTagRegex)
+	}
+	if opts.minSizeMB > 0 {
+		logger.Infof("Only deleting images larger than %d MB", opts.minSizeMB)
+	}
+	if opts.resourceTags != "" {
+		logger.Infof("Resource tag filter: %s", opts.resourceTags)
+	}
+	if opts.prefixesFile != "" {
+		logger.Infof("Prefixes: %d in effect, including those loaded from %s", len(opts.policy.Matchers), opts.prefixesFile)
+	}
+	if opts.matcherFile != "" {
+		logger.Infof("Tag matchers: %d in effect, including those loaded from %s", len(opts.policy.Matchers), opts.matcherFile)
+	}
+	if opts.excludePrefixes != "" {
+		logger.Infof("Always keeping images with tags starting with: %s", opts.excludePrefixes)
+	}
+	if opts.mediaTypes != "" {
+		logger.Infof("Only deleting images with media types: %s", opts.mediaTypes)
+	}
+	if opts.policyFile != "" {
+		logger.Infof("Policy rules: %d loaded from %s", len(opts.policy.Rules), opts.policyFile)
+	}
+	if opts.lifecycleFile != "" {
+		logger.Infof("Comparing the plan with the lifecycle policy rules: %d loaded from %s", len(opts.policy.CompareLifecycle.Rules), opts.lifecycleFile)
+	}
+	if len(opts.policy.ProtectDigests) > 0 {
+		logger.Infof("Protected digests: %d", len(opts.policy.ProtectDigests))
+	}
+	if opts.protectReposFile != "" {
+		logger.Infof("Protected repositories: %d loaded from %s", len(opts.policy.ProtectRepos), opts.protectReposFile)
+	}
+	if opts.keepBy == cleaner.KeepBySemver {
+		logger.Infof("Keeping the highest semantic versions per prefix instead of the newest pushes")
+	}
+	if opts.keepBy == cleaner.KeepByVersion {
+		logger.Infof("Keeping every image of the most recently pushed versions per prefix instead of the newest pushes")
+	}
+	if len(opts.policy.KeepMap) > 0 {
+		logger.Infof("Per-prefix keep counts: %s", opts.keepList)
+	}
+	if len(opts.policy.KeepByRepo) > 0 {
+		logger.Infof("Per-repository keep counts: %s", opts.keepRepoList)
+	}
+	if len(opts.policy.GraceMap) > 0 {
+		logger.Infof("Per-prefix grace periods: %s (others: %s)", opts.graceList, opts.gracePeriod)
+	}
+	if !opts.policy.Before.IsZero() {
+		logger.Infof("Cutoff date: %s (combined with retention using %q)",
+			opts.policy.Before.Format(time.RFC3339), opts.cutoffMode)
+	}
+	profileName := opts.profile
+	if profileName == "" {
+		profileName = "default"
+	}
+	if opts.simulation == nil {
+		logger.Infof("Using AWS profile: %s", profileName)
+	}
+	if opts.regionSource != "" {
+		logger.Infof("Using AWS region %s (from %s)", strings.Join(regions, ","), opts.regionSource)
+	}
+	if opts.roleArn != "" {
+		logger.Infof("Assuming role: %s", opts.roleArn)
+	}
+	if opts.useFIPS {
+		logger.Infof("Using FIPS endpoints")
+	}
+	if opts.pprofAddr != "" {
+		stopPprof, err := startPprof(opts.pprofAddr)
+		if err != nil {
+			logger.Errorf("❌ Failed to start pprof on %s: %v", opts.pprofAddr, err)
+			return 1
+		}
+		defer stopPprof()
+	}
 
-			// Delete if older than retention
-			if imageAge > retention {
-				logger.Printf("[DELETE] 🗑️ Old image to delete: %s | Age: %d days | Tags: %v",
-					*image.ImageDigest, imageAge, image.ImageTags)
-
-				if !dryRun {
-					_, err := svc.BatchDeleteImage(&ecr.BatchDeleteImageInput{
-						RepositoryName: aws.String(repoName),
-						ImageIds: []*ecr.ImageIdentifier{
-							{ImageDigest: image.ImageDigest},
-						},
-					})
-					if err != nil {
-						logger.Printf("[ERROR] ❌ Error deleting image %s: %v", *image.ImageDigest, err)
-					} else {
-						logger.Printf("[SUCCESS] ✅ Image deleted: %s", *image.ImageDigest)
-					}
-				}
+	if opts.stateFile != "" {
+		opts.state, err = cleaner.OpenStateFile(opts.stateFile)
+		if err != nil {
+			logger.Errorf("Failed to open state file %s: %v", opts.stateFile, err)
+			return 1
+		}
+		defer opts.state.Close()
+		if opts.state.Resumed() {
+			logger.Infof("♻️ Resuming from state file %s", opts.stateFile)
+		}
+		if opts.since == sinceLastRun {
+			if last := opts.state.LastScan(); last.IsZero() {
+				logger.Infof("🔁 Incremental run: no earlier run in %s, scanning every repository", opts.stateFile)
+			} else {
+				logger.Infof("🔁 Incremental run: last run at %s, full scan of each repository every %s",
+					last.Format(time.RFC3339), opts.fullScanAfter)
 			}
 		}
 	}
 
-	logger.Println("[INFO] ✅ ECR cleanup completed.")
+	if opts.simulation != nil {
+		logger.Infof("🧪 Simulation: images are read from %s and no AWS call is made", opts.simulate)
+	} else {
+		provider, err := checkCredentials(opts, regions[0])
+		if err != nil && opts.webIdentity {
+			logger.Errorf("❌ Could not assume %s with the web identity token in %s: %v",
+				os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), err)
+			return 1
+		}
+		if err != nil {
+			logger.Errorf("❌ Could not resolve AWS credentials for profile %s: %v (run `aws sso login` for an SSO profile; "+
+				"pass -shared-config to read ~/.aws/config without -profile)", profileName, err)
+			return 1
+		}
+		logger.Infof("🔑 AWS credentials resolved by %s", provider)
+	}
+
+	// Ctrl-C, SIGTERM or the -timeout deadline stop the run gracefully
+	// with a partial summary.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.listRepos {
+		if err := listRepos(ctx, opts, regions); err != nil {
+			logger.Errorf("❌ Failed to list repositories: %v", err)
+			return 1
+		}
+		return 0
+	}
+	if opts.stats {
+		if err := printStats(ctx, opts, regions); err != nil {
+			logger.Errorf("❌ Failed to collect repository stats: %v", err)
+			return 1
+		}
+		return 0
+	}
+	if opts.serveAddr != "" {
+		if err := serve(ctx, opts, regions); err != nil {
+			logger.Errorf("❌ Server failed: %v", err)
+			return 1
+		}
+		return 0
+	}
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	// Without delete permissions every deletion would fail, so the run
+	// falls back to a dry run instead
+	if !opts.dryRun && !opts.skipPreflight {
+		preflight(ctx, &opts, regions)
+	}
+
+	if opts.dryRun && !opts.planOnly && !opts.reportOnly {
+		logger.Infof("Dry run: nothing will be deleted. Pass -confirm-delete to delete images.")
+	}
+	if opts.confirmEach {
+		if opts.dryRun {
+			logger.Warnf("-confirm-each has no effect in a dry run")
+		}
+		opts.prompter = cleaner.NewPrompter(os.Stdin, os.Stderr)
+	}
+	if opts.interactive && !opts.dryRun {
+		if err := confirmInteractiveDelete(ctx, opts, regions); err != nil {
+			logger.Errorf("❌ Aborting before deleting anything: %v", err)
+			return 1
+		}
+	}
+
+	// The cap is checked before anything is deleted in any region
+	if (opts.maxDelete > 0 || opts.maxReclaimMB > 0) && !opts.dryRun {
+		if err := checkDeleteCap(ctx, &opts, regions); err != nil {
+			logger.Errorf("❌ Aborting before deleting anything: %v", err)
+			return 1
+		}
+	}
+
+	var report *cleaner.ReportWriter
+	if opts.reportPath != "" {
+		var err error
+		report, err = cleaner.NewReportWriter(opts.reportPath)
+		if err != nil {
+			logger.Errorf("Failed to create report %s: %v", opts.reportPath, err)
+			return 1
+		}
+		defer report.Close()
+	}
+
+	summary, regionSummaries := cleanupRegions(ctx, opts, regions, report)
+	summary.DurationSeconds = time.Since(startTime).Seconds()
+	stopped := ctx.Err()
+	if stopped != nil {
+		summary.Aborted = true
+	}
+	// A second Ctrl-C now terminates the process immediately
+	stop()
+
+	if report != nil {
+		if err := report.Close(); err != nil {
+			logger.Errorf("Failed to write report %s: %v", opts.reportPath, err)
+		}
+	}
+
+	printSummary(opts, summary)
+	if stopped != nil {
+		logger.Errorf("❌ Run stopped early (%s); the summary is partial", stopReason(stopped, opts.timeout))
+	}
+
+	if opts.reportOnly && opts.output != outputJSON {
+		printReclaimable(summary)
+	}
+	if opts.historyFile != "" {
+		if err := appendHistory(opts.historyFile, startTime, opts.dryRun, regionSummaries); err != nil {
+			logger.Errorf("Failed to write history file %s: %v", opts.historyFile, err)
+		}
+	}
+	if opts.planOnly || opts.reportOnly {
+		if stopped != nil {
+			return 1
+		}
+		closeState(opts, false)
+		if opts.planOnly {
+			logger.Infof("✅ Plan complete; nothing was changed (-plan-only).")
+		} else {
+			logger.Infof("✅ Report complete; nothing was changed (-report-only).")
+		}
+		return 0
+	}
+
+	notify(opts, regions, summary, regionSummaries)
+
+	if stopped != nil {
+		return 1
+	}
+	if summary.HasErrors() {
+		logger.Errorf("❌ ECR cleanup completed with errors: %d failed deletions, %d failed repositories, %d failed regions",
+			summary.Totals.Failed, summary.FailedRepositories, len(summary.FailedRegions))
+		if !opts.ignoreErrors {
+			return 1
+		}
+		logger.Warnf("⚠️ Exiting with status 0 despite the errors (-ignore-errors)")
+		return 0
+	}
+	closeState(opts, !opts.dryRun)
+	logger.Infof("✅ ECR cleanup completed.")
+	return 0
+}
+
+// cleanupRegions cleans up every region and returns the summary of the
+// whole run together with one summary per region. Regions are processed
+// one after another, or with -parallel-regions concurrently; the summary
+// aggregates them while the log keeps a breakdown per region.
+func cleanupRegions(ctx context.Context, opts options, regions []string, report *cleaner.ReportWriter) (cleaner.RunSummary, []cleaner.RunSummary) {
+	summary := cleaner.RunSummary{DryRun: opts.dryRun, Repositories: []cleaner.RepoSummary{}}
+	if opts.parallelRegions {
+		return runRegionsParallel(ctx, opts, regions, report, summary)
+	}
+
+	var regionSummaries []cleaner.RunSummary
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			break
+		}
+		logger.Infof("==================== 🌍 Region: %s ====================", region)
+		regionSummary, err := runRegion(ctx, opts, region, report, logger)
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			logger.Errorf("Region %s failed: %v", region, err)
+			summary.FailedRegions = append(summary.FailedRegions, region)
+			regionSummaries = append(regionSummaries, cleaner.RunSummary{Regions: []string{region}, FailedRegions: []string{region}})
+			continue
+		}
+		logTotals(opts, "Region "+region, regionSummary)
+		summary.Merge(regionSummary)
+		regionSummaries = append(regionSummaries, regionSummary)
+	}
+	return summary, regionSummaries
+}
+
+// notify publishes the summary to SNS and the webhook, and the per-region
+// metrics to the pushgateway, when they are configured. Failures are logged
+// but do not affect the outcome of the run.
+func notify(opts options, regions []string, summary cleaner.RunSummary, regionSummaries []cleaner.RunSummary) {
+	if opts.snsTopicArn != "" {
+		sess, err := newSession(opts, topicRegion(opts.snsTopicArn, regions[0]))
+		if err != nil {
+			logger.Errorf("Failed to create session for SNS: %v", err)
+		} else {
+			publishSummary(sns.New(sess, clientConfig(sess, opts)), opts.snsTopicArn, summary)
+		}
+	}
+	if opts.webhookURL != "" {
+		// The run's context is already done when it was stopped early, but
+		// the partial summary is still posted; another signal stops the
+		// retries.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := postWebhook(ctx, opts, summary); err != nil {
+			logger.Errorf("Failed to post summary to webhook: %v", err)
+		}
+	}
+	if opts.pushgateway != "" {
+		pushMetrics(opts.pushgateway, regionSummaries)
+	}
+}
+
+// closeState closes the state file once the run has finished. A finished
+// run leaves nothing to resume, so the file is removed; it is also removed
+// when it is empty, as a dry run never writes to it. An incremental run
+// keeps the scans the next run needs, and drops the rest.
+func closeState(opts options, finished bool) {
+	if opts.state == nil {
+		return
+	}
+	if opts.since == sinceLastRun {
+		closeFile := opts.state.Close
+		if finished {
+			closeFile = opts.state.Compact
+		}
+		if err := closeFile(); err != nil {
+			logger.Errorf("Failed to close state file %s: %v", opts.stateFile, err)
+		}
+		return
+	}
+	if finished || !opts.state.Resumed() {
+		if err := opts.state.Remove(); err != nil {
+			logger.Errorf("Failed to remove state file %s: %v", opts.stateFile, err)
+		}
+		return
+	}
+	if err := opts.state.Close(); err != nil {
+		logger.Errorf("Failed to close state file %s: %v", opts.stateFile, err)
+	}
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"scripts/cleaner"
+	"scripts/logging"
+)
+
+func TestValidate(t *testing.T) {
+	valid := options{region: "us-east-1", retention: 30, keep: 2, output: outputText, concurrency: 5, matchMode: matchPrefix, cutoffMode: cleaner.CutoffAnd, logFormat: logging.FormatText, logLevel: "info", keepBy: cleaner.KeepByPushed, deleteWorkers: 4, sortOrder: cleaner.SortAsc, fullScanAfter: 24 * time.Hour, webhookTimeout: 10 * time.Second}
+	tests := []struct {
+		name    string
+		change  func(*options)
+		wantErr string
+	}{
+		{"valid", func(*options) {}, ""},
+		{"no region", func(o *options) { o.region = "" }, "region must not be empty"},
+		{"external ID without a role", func(o *options) { o.externalID = "x" }, "requires assume-role-arn"},
+		{"negative retention", func(o *options) { o.retention = -1 }, "retention must be non-negative"},
+		{"keep nothing", func(o *options) { o.keep = 0 }, "keep must be at least 1"},
+		{"unknown output", func(o *options) { o.output = "yaml" }, `output must be "text", "json" or "table"`},
+		{"no workers", func(o *options) { o.concurrency = 0 }, "concurrency must be at least 1"},
+		{"unknown match mode", func(o *options) { o.matchMode = "glob" }, `match-mode must be "prefix" or "regex"`},
+		{"unknown cutoff mode", func(o *options) { o.cutoffMode = "xor" }, `cutoff-mode must be "and" or "or"`},
+		{"unknown log format", func(o *options) { o.logFormat = "xml" }, `log-format must be "text" or "json"`},
+		{"negative log size", func(o *options) { o.logMaxSizeMB = -1 }, "log-max-size-mb must be non-negative"},
+		{"negative delete cap", func(o *options) { o.maxDeleteRepo = -1 }, "max-delete and max-delete-per-repo must be non-negative"},
+		{"unknown log level", func(o *options) { o.logLevel = "trace" }, "unknown log level"},
+		{"unknown keep-by", func(o *options) { o.keepBy = "tag" }, `keep-by must be "pushed", "semver" or "version"`},
+		{"no delete workers", func(o *options) { o.deleteWorkers = 0 }, "delete-concurrency must be at least 1"},
+		{"prompts from parallel regions", func(o *options) { o.confirmEach, o.parallelRegions = true, true }, "confirm-each cannot be combined with parallel-regions"},
+		{"negative unpulled days", func(o *options) { o.unpulledDays = -1 }, "unpulled-days must be non-negative"},
+		{"serve with a state file", func(o *options) { o.serveAddr, o.stateFile = ":8080", "state.json" }, "serve cannot be combined"},
+		{"unknown sort order", func(o *options) { o.sortOrder = "random" }, `sort must be "asc" or "desc"`},
+		{"untagged first without a cap", func(o *options) { o.untaggedFirst = true }, "untagged-first requires max-delete"},
+		{"no full scan", func(o *options) { o.fullScanAfter = 0 }, "full-scan-after must be positive"},
+		{"no webhook timeout", func(o *options) { o.webhookTimeout = 0 }, "webhook-timeout must be positive"},
+		{"negative retention duration", func(o *options) { o.retentionDuration = -time.Minute }, "retention-duration must be non-negative"},
+		{"verbose without the table", func(o *options) { o.verbose = true }, "verbose requires output table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := valid
+			tt.change(&opts)
+			err := opts.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeepMap(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    map[string]int
+		wantErr string
+	}{
+		{list: "", want: map[string]int{}},
+		{list: "prod=10,dev=2,latest=5", want: map[string]int{"prod": 10, "dev": 2, "latest": 5}},
+		{list: "prod", wantErr: "must be in the form name=count"},
+		{list: "=3", wantErr: "must be in the form"},
+		{list: "prod=ten", wantErr: "non-numeric count"},
+		{list: "prod=0", wantErr: "at least 1 image"},
+		{list: "prod=1,dev=2,prod=3", wantErr: `"prod=3" repeats prod`},
+	}
+	for _, tt := range tests {
+		got, err := parseKeepMap(tt.list)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseKeepMap(%q) err = %v, want %q", tt.list, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseKeepMap(%q): %v", tt.list, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseKeepMap(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+		for prefix, n := range tt.want {
+			if got[prefix] != n {
+				t.Errorf("parseKeepMap(%q)[%q] = %d, want %d", tt.list, prefix, got[prefix], n)
+			}
+		}
+	}
+}
+
+func TestParseGraceMap(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    map[string]time.Duration
+		wantErr string
+	}{
+		{list: "", want: map[string]time.Duration{}},
+		{list: "release-=168h, hotfix-=0", want: map[string]time.Duration{"release-": 168 * time.Hour, "hotfix-": 0}},
+		{list: "release-", wantErr: "must be in the form prefix=duration"},
+		{list: "=24h", wantErr: "must be in the form"},
+		{list: "release-=7d", wantErr: "invalid duration"},
+		{list: "hotfix-=-1h", wantErr: "negative duration"},
+		{list: "release-=24h,release-=48h", wantErr: `"release-=48h" repeats release-`},
+	}
+	for _, tt := range tests {
+		got, err := parseGraceMap(tt.list)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseGraceMap(%q) err = %v, want %q", tt.list, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGraceMap(%q): %v", tt.list, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseGraceMap(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+		for prefix, grace := range tt.want {
+			if d, ok := got[prefix]; !ok || d != grace {
+				t.Errorf("parseGraceMap(%q)[%q] = %s, want %s", tt.list, prefix, d, grace)
+			}
+		}
+	}
+}
+
+func TestParseBuildsThePolicy(t *testing.T) {
+	opts := options{keep: 2, keepList: "prod=10,dev=1", keepRepoList: "team/api=5", prefixList: "prod,dev", matchMode: matchPrefix, protectList: "release-stable, prod-pinned"}
+	if err := opts.parse(); err != nil {
+		t.Fatal(err)
+	}
+	p := opts.policy
+	if p.Keep != 2 || len(p.KeepMap) != 2 || p.KeepMap["prod"] != 10 || p.KeepMap["dev"] != 1 {
+		t.Errorf("keep %d, keep map %v; want 2 and the two entries", p.Keep, p.KeepMap)
+	}
+	if len(p.KeepByRepo) != 1 || p.KeepByRepo["team/api"] != 5 {
+		t.Errorf("keep by repo %v, want team/api=5", p.KeepByRepo)
+	}
+	if len(p.Matchers) != 2 || p.Matchers[1].Pattern != "dev" {
+		t.Errorf("matchers %v, want prod and dev", p.Matchers)
+	}
+	if !p.ProtectTags["release-stable"] || !p.ProtectTags["prod-pinned"] {
+		t.Errorf("protected tags %v, want both tags", p.ProtectTags)
+	}
+}
+
+func TestParseProtectDigests(t *testing.T) {
+	opts := options{keep: 1, matchMode: matchPrefix, protectDigests: "sha256:aaa, sha256:bbb"}
+	if err := opts.parse(); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.policy.ProtectDigests) != 2 || !opts.policy.ProtectDigests["sha256:bbb"] {
+		t.Errorf("protected digests %v, want both digests", opts.policy.ProtectDigests)
+	}
+	opts = options{keep: 1, matchMode: matchPrefix, protectDigests: "sha256:aaa,v1.2.0"}
+	if err := opts.parse(); err == nil || !strings.Contains(err.Error(), `"v1.2.0": must start with sha256:`) {
+		t.Errorf("parse err = %v, want the malformed digest named", err)
+	}
+}
+
+func TestCheckDeleteCap(t *testing.T) {
+	logger = logging.New(io.Discard, logging.FormatText)
+	sim, err := loadSimulation(writeFixture(t, `{"repositories": [
+		{"repositoryName": "web", "imageDetails": [
+			{"imageDigest": "sha256:old1", "imagePushedAt": "2025-01-01T00:00:00Z", "imageSizeInBytes": 1048576},
+			{"imageDigest": "sha256:old2", "imagePushedAt": "2025-01-02T00:00:00Z", "imageSizeInBytes": 1048576},
+			{"imageDigest": "sha256:old3", "imageTags": ["build-3"], "imagePushedAt": "2025-01-03T00:00:00Z", "imageSizeInBytes": 1048576},
+			{"imageDigest": "sha256:new", "imageTags": ["build-4"], "imagePushedAt": "2026-10-13T00:00:00Z", "imageSizeInBytes": 1048576}
+		]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := cleaner.Config{Retention: 30, Keep: 1, DeleteUntagged: true, IncludeUnmatched: true, Concurrency: 1}
+	tests := []struct {
+		name          string
+		maxDelete     int
+		untaggedFirst bool
+		wantErr       string
+		wantOnly      int
+	}{
+		{name: "within the cap", maxDelete: 3},
+		{name: "over the cap", maxDelete: 2, wantErr: "3 images slated for deletion exceeds -max-delete 2"},
+		{name: "untagged first trims the run", maxDelete: 2, untaggedFirst: true, wantOnly: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options{policy: policy, simulation: sim, maxDelete: tt.maxDelete, untaggedFirst: tt.untaggedFirst}
+			err := checkDeleteCap(context.Background(), &opts, []string{simulatedRegion})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("checkDeleteCap err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkDeleteCap: %v", err)
+			}
+			if len(opts.policy.DeleteOnly) != tt.wantOnly {
+				t.Errorf("delete only %v, want %d images", opts.policy.DeleteOnly, tt.wantOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"scripts/cleaner"
+)
+
+// metricsNamespace is the CloudWatch namespace the run metrics go under.
+const metricsNamespace = "ECRCleanup"
+
+// maxMetricDataPerCall is the number of datums PutMetricData accepts in a
+// single call.
+const maxMetricDataPerCall = 20
+
+// summaryMetrics converts the totals of a region's run into CloudWatch
+// datums dimensioned by region.
+func summaryMetrics(region string, summary cleaner.RunSummary) []*cloudwatch.MetricDatum {
+	now := time.Now()
+	dimensions := []*cloudwatch.Dimension{
+		{Name: aws.String("Region"), Value: aws.String(region)},
+	}
+	datum := func(name string, value float64, unit string) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dimensions,
+			Timestamp:  aws.Time(now),
+			Unit:       aws.String(unit),
+			Value:      aws.Float64(value),
+		}
+	}
+	return []*cloudwatch.MetricDatum{
+		datum("ImagesDeleted", float64(summary.Totals.Deleted), cloudwatch.StandardUnitCount),
+		datum("ImagesRetained", float64(summary.Totals.Retained), cloudwatch.StandardUnitCount),
+		datum("BytesReclaimed", float64(summary.Totals.ReclaimedBytes), cloudwatch.StandardUnitBytes),
+	}
+}
+
+// emitMetrics publishes the run metrics to CloudWatch in batches of
+// maxMetricDataPerCall. Failures are logged but do not abort the run.
+func emitMetrics(svc cloudwatchiface.CloudWatchAPI, region string, summary cleaner.RunSummary) {
+	data := summaryMetrics(region, summary)
+	for start := 0; start < len(data); start += maxMetricDataPerCall {
+		end := start + maxMetricDataPerCall
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(metricsNamespace),
+			MetricData: data[start:end],
+		})
+		if err != nil {
+			logger.Errorf("Failed to publish CloudWatch metrics for %s: %v", region, err)
+			return
+		}
+	}
+	logger.Infof("Published %d CloudWatch metrics for %s to namespace %s", len(data), region, metricsNamespace)
+}
+
+// pushgatewayJob is the job label the Prometheus metrics are pushed under.
+const pushgatewayJob = "ecr_cleanup"
+
+// pushMetrics pushes per-region gauges of the run to a Prometheus
+// pushgateway. Failures are logged but do not abort the run.
+func pushMetrics(url string, regions []cleaner.RunSummary) {
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{"region"})
+	}
+	deleted := gauge("ecr_cleanup_images_deleted", "Images deleted, or that would be deleted in dry-run mode.")
+	reclaimed := gauge("ecr_cleanup_bytes_reclaimed", "Bytes reclaimed, or that would be reclaimed in dry-run mode.")
+	processed := gauge("ecr_cleanup_repos_processed", "Repositories processed.")
+	errs := gauge("ecr_cleanup_errors_total", "Failed deletions, repositories and regions.")
+
+	for _, summary := range regions {
+		for _, region := range summary.Regions {
+			deleted.WithLabelValues(region).Set(float64(summary.Totals.Deleted))
+			reclaimed.WithLabelValues(region).Set(float64(summary.Totals.ReclaimedBytes))
+			processed.WithLabelValues(region).Set(float64(len(summary.Repositories)))
+			errs.WithLabelValues(region).Set(float64(summary.Totals.Failed + summary.FailedRepositories + len(summary.FailedRegions)))
+		}
+	}
+
+	err := push.New(url, pushgatewayJob).
+		Collector(deleted).Collector(reclaimed).Collector(processed).Collector(errs).
+		Push()
+	if err != nil {
+		logger.Errorf("Failed to push metrics to %s: %v", url, err)
+		return
+	}
+	logger.Infof("Pushed metrics for %d regions to %s", len(regions), url)
+}
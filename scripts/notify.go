@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+
+	"scripts/cleaner"
+)
+
+// summaryMessage renders the run summary as a short plain-text message.
+func summaryMessage(summary cleaner.RunSummary) string {
+	mode := "cleanup"
+	if summary.DryRun {
+		mode = "dry-run"
+	}
+	return fmt.Sprintf("ECR %s in %s finished in %.0fs\n"+
+		"Scanned: %d images in %d repositories\n"+
+		"Deleted: %d (%d untagged)\n"+
+		"Failed: %d deletions, %d repositories\n"+
+		"Reclaimed: %s",
+		mode, strings.Join(summary.Regions, ", "), summary.DurationSeconds,
+		summary.Totals.Scanned, len(summary.Repositories),
+		summary.Totals.Deleted, summary.Totals.UntaggedDeleted,
+		summary.Totals.Failed, summary.FailedRepositories,
+		cleaner.FormatBytes(summary.Totals.ReclaimedBytes))
+}
+
+// topicRegion returns the region encoded in an SNS topic ARN, or fallback
+// when the ARN cannot be parsed.
+func topicRegion(topicArn, fallback string) string {
+	parsed, err := arn.Parse(topicArn)
+	if err != nil || parsed.Region == "" {
+		return fallback
+	}
+	return parsed.Region
+}
+
+// publishSummary sends the run summary to an SNS topic. Failures are
+// logged but do not affect the outcome of the run.
+func publishSummary(svc snsiface.SNSAPI, topicArn string, summary cleaner.RunSummary) {
+	subject := fmt.Sprintf("ECR cleanup summary (%s)", strings.Join(summary.Regions, ", "))
+	_, err := svc.Publish(&sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(summaryMessage(summary)),
+	})
+	if err != nil {
+		logger.Errorf("Failed to publish summary to SNS topic %s: %v", topicArn, err)
+		return
+	}
+	logger.Infof("Published summary to SNS topic %s", topicArn)
+}
+
+// webhookAttempts is how many times a webhook delivery is tried before
+// giving up; only transport errors and 5xx responses are retried.
+const webhookAttempts = 3
+
+// postWebhook POSTs the JSON run summary to the webhook URL, with the
+// configured headers. It stops retrying once ctx is done.
+func postWebhook(ctx context.Context, opts options, summary cleaner.RunSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+	// Webhook URLs such as Slack's carry their secret in the path, so only
+	// the host is logged.
+	host := opts.webhookURL
+	if u, err := url.Parse(opts.webhookURL); err == nil {
+		host = u.Host
+	}
+	client := &http.Client{Timeout: opts.webhookTimeout}
+	for attempt := 1; ; attempt++ {
+		retry, err := sendWebhook(ctx, client, opts, body)
+		if err == nil {
+			logger.Infof("Posted summary to webhook on %s", host)
+			return nil
+		}
+		if !retry || attempt == webhookAttempts {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+		backoff := time.Duration(attempt) * time.Second
+		logger.Warnf("Webhook on %s failed (attempt %d/%d), retrying in %s: %v", host, attempt, webhookAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// sendWebhook makes a single webhook request, reporting whether a failure
+// is worth retrying.
+func sendWebhook(ctx context.Context, client *http.Client, opts options, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range opts.webhookHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error quotes the full URL.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
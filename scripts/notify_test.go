@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scripts/cleaner"
+	"scripts/logging"
+)
+
+func TestPostWebhookStopsRetryingWhenCanceled(t *testing.T) {
+	logger = logging.New(io.Discard, logging.FormatText)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		// The run is stopped while the first attempt fails
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	err := postWebhook(ctx, options{webhookURL: srv.URL, webhookTimeout: time.Second}, cleaner.RunSummary{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if attempts != 1 || time.Since(start) >= time.Second {
+		t.Errorf("%d attempts in %s, want one and no backoff", attempts, time.Since(start))
+	}
+}
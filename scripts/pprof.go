@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// startPprof serves the net/http/pprof profiles on addr under
+// /debug/pprof/ for the length of the run. It is a diagnostic tool: the
+// mutex and block profiles are sampled while it is on, to find contention
+// around the shared logger and locks under a high -concurrency. The
+// returned function shuts the server down; run defers it, so it is called
+// on every exit path.
+func startPprof(addr string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	runtime.SetMutexProfileFraction(10)
+	runtime.SetBlockProfileRate(int(time.Millisecond))
+
+	// Importing net/http/pprof also registers the profiles on
+	// http.DefaultServeMux, which no server here uses. This server has a
+	// mux of its own, so the -serve address never exposes them.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logger.Warnf("⚠️ pprof server failed: %v", err)
+		}
+	}()
+	logger.Infof("🩺 pprof listening on http://%s/debug/pprof/ (diagnostics only)", listener.Addr())
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		runtime.SetMutexProfileFraction(0)
+		runtime.SetBlockProfileRate(0)
+	}, nil
+}
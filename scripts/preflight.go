@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// errCodeAccessDenied is the error code AWS returns for a call the
+// caller's IAM policies do not allow.
+const errCodeAccessDenied = "AccessDeniedException"
+
+// probeDigest is a well-formed digest that no image has, so the preflight
+// probe never deletes anything.
+var probeDigest = "sha256:" + strings.Repeat("0", 64)
+
+// preflight checks that images may be deleted in every region and, if not,
+// switches opts to a dry run with a prominent warning.
+func preflight(ctx context.Context, opts *options, regions []string) {
+	var denied []string
+	for _, region := range regions {
+		allowed, err := preflightDelete(ctx, *opts, region)
+		if err != nil {
+			logger.Warnf("Preflight permission check in %s failed: %v", region, err)
+			continue
+		}
+		if !allowed {
+			denied = append(denied, region)
+		}
+	}
+	if len(denied) > 0 {
+		logger.Warnf("⚠️ ==================== DRY RUN ====================")
+		logger.Warnf("⚠️ Missing ecr:BatchDeleteImage permission in %s; continuing as a dry run, nothing will be deleted", strings.Join(denied, ","))
+		opts.dryRun = true
+		opts.policy.DryRun = true
+	}
+}
+
+// preflightDelete reports whether the caller may delete images in the
+// region. It deletes probeDigest from one of the repositories that will be
+// cleaned up: ECR checks the permission before it looks up the image, so
+// the call fails with AccessDeniedException without ecr:BatchDeleteImage
+// and reports the image as not found with it. A region without
+// repositories has nothing to delete and passes.
+func preflightDelete(ctx context.Context, opts options, region string) (bool, error) {
+	sess, err := newSession(opts, region)
+	if err != nil {
+		return false, err
+	}
+	svc := newECRClient(sess, clientConfig(sess, opts), opts)
+
+	var repoName string
+	if len(opts.policy.Repositories) > 0 {
+		repoName = opts.policy.Repositories[0]
+	} else {
+		out, err := svc.DescribeRepositoriesWithContext(ctx, &ecr.DescribeRepositoriesInput{MaxResults: aws.Int64(1)})
+		if err != nil {
+			return false, err
+		}
+		if len(out.Repositories) == 0 {
+			return true, nil
+		}
+		repoName = aws.StringValue(out.Repositories[0].RepositoryName)
+	}
+
+	_, err = svc.BatchDeleteImageWithContext(ctx, &ecr.BatchDeleteImageInput{
+		RepositoryName: aws.String(repoName),
+		ImageIds:       []*ecr.ImageIdentifier{{ImageDigest: aws.String(probeDigest)}},
+	})
+	var awsErr awserr.Error
+	switch {
+	case errors.As(err, &awsErr) && awsErr.Code() == errCodeAccessDenied:
+		return false, nil
+	case errors.As(err, &awsErr) && awsErr.Code() == ecr.ErrCodeRepositoryNotFoundException:
+		// A named repository that does not exist is skipped by the run
+		return true, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"scripts/cleaner"
+)
+
+// serve runs the cleaner as a service on addr until ctx is done. GET
+// /healthz reports that the server is up, and POST /run cleans up the
+// regions with the configured settings and returns the JSON summary. Only
+// one run is in progress at a time; a trigger during a run gets 409.
+func serve(ctx context.Context, opts options, regions []string) error {
+	var running sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /run", func(w http.ResponseWriter, r *http.Request) {
+		if !running.TryLock() {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a run is already in progress"})
+			return
+		}
+		defer running.Unlock()
+
+		summary, err := serveRun(ctx, opts, regions)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, summary)
+	})
+
+	server := &http.Server{Addr: opts.serveAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	logger.Infof("🌐 Serving on %s: GET /healthz, POST /run", opts.serveAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveRun performs one run triggered through /run. It follows the
+// one-shot run, with the preflight check, the -max-delete cap, the report,
+// the history file and the notifications, but is not bound to the life of
+// the request: a client that disconnects does not stop the cleanup.
+func serveRun(ctx context.Context, opts options, regions []string) (cleaner.RunSummary, error) {
+	startTime := time.Now()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	logger.Infof("▶️ Run triggered through /run")
+
+	if !opts.dryRun && !opts.skipPreflight {
+		preflight(ctx, &opts, regions)
+	}
+	if (opts.maxDelete > 0 || opts.maxReclaimMB > 0) && !opts.dryRun {
+		if err := checkDeleteCap(ctx, &opts, regions); err != nil {
+			logger.Errorf("❌ Aborting before deleting anything: %v", err)
+			return cleaner.RunSummary{}, err
+		}
+	}
+
+	var report *cleaner.ReportWriter
+	if opts.reportPath != "" {
+		var err error
+		report, err = cleaner.NewReportWriter(opts.reportPath)
+		if err != nil {
+			return cleaner.RunSummary{}, err
+		}
+		defer report.Close()
+	}
+
+	summary, regionSummaries := cleanupRegions(ctx, opts, regions, report)
+	summary.DurationSeconds = time.Since(startTime).Seconds()
+	if ctx.Err() != nil {
+		summary.Aborted = true
+	}
+	if report != nil {
+		if err := report.Close(); err != nil {
+			logger.Errorf("Failed to write report %s: %v", opts.reportPath, err)
+		}
+	}
+
+	logTotals(opts, "Total", summary)
+	if opts.historyFile != "" {
+		if err := appendHistory(opts.historyFile, startTime, opts.dryRun, regionSummaries); err != nil {
+			logger.Errorf("Failed to write history file %s: %v", opts.historyFile, err)
+		}
+	}
+	if !opts.planOnly && !opts.reportOnly {
+		notify(opts, regions, summary, regionSummaries)
+	}
+	return summary, nil
+}
+
+// writeJSON writes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// simulatedRegion is the region a simulation reports when none is set.
+const simulatedRegion = "simulated"
+
+// errSimulated is returned by the calls a simulation never makes, as it is
+// always a dry run.
+var errSimulated = errors.New("not available in a simulation")
+
+// simulationFile is the JSON fixture loaded with -simulate. Each
+// repository lists its images with the fields of the ECR ImageDetail, as
+// printed by `aws ecr describe-images`, so real output can be pasted in.
+type simulationFile struct {
+	Repositories []struct {
+		RepositoryName string             `json:"repositoryName"`
+		ImageDetails   []*ecr.ImageDetail `json:"imageDetails"`
+	} `json:"repositories"`
+}
+
+// simulatedECR serves the repositories and images of a fixture file in
+// place of ECR, so the cleanup runs without any AWS call.
+type simulatedECR struct {
+	names  []string
+	images map[string][]*ecr.ImageDetail
+}
+
+// loadSimulation reads a -simulate fixture file, rejecting unknown fields,
+// repositories without a name or listed twice, and images without a
+// digest.
+func loadSimulation(name string) (*simulatedECR, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var file simulationFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	sim := &simulatedECR{images: make(map[string][]*ecr.ImageDetail)}
+	for _, repo := range file.Repositories {
+		if repo.RepositoryName == "" {
+			return nil, fmt.Errorf("%s: repository without a repositoryName", name)
+		}
+		if _, ok := sim.images[repo.RepositoryName]; ok {
+			return nil, fmt.Errorf("%s: repository %q is listed twice", name, repo.RepositoryName)
+		}
+		for i, image := range repo.ImageDetails {
+			if image == nil || aws.StringValue(image.ImageDigest) == "" {
+				return nil, fmt.Errorf("%s: image %d of repository %q has no imageDigest", name, i+1, repo.RepositoryName)
+			}
+		}
+		sim.names = append(sim.names, repo.RepositoryName)
+		sim.images[repo.RepositoryName] = repo.ImageDetails
+	}
+	return sim, nil
+}
+
+// imageCount returns the number of images in the fixture.
+func (s *simulatedECR) imageCount() int {
+	n := 0
+	for _, images := range s.images {
+		n += len(images)
+	}
+	return n
+}
+
+func (s *simulatedECR) DescribeRepositoriesPagesWithContext(_ aws.Context, _ *ecr.DescribeRepositoriesInput, fn func(*ecr.DescribeRepositoriesOutput, bool) bool, _ ...request.Option) error {
+	out := &ecr.DescribeRepositoriesOutput{}
+	for _, name := range s.names {
+		out.Repositories = append(out.Repositories, &ecr.Repository{RepositoryName: aws.String(name)})
+	}
+	fn(out, true)
+	return nil
+}
+
+func (s *simulatedECR) DescribeImagesPagesWithContext(_ aws.Context, in *ecr.DescribeImagesInput, fn func(*ecr.DescribeImagesOutput, bool) bool, _ ...request.Option) error {
+	images, ok := s.images[aws.StringValue(in.RepositoryName)]
+	if !ok {
+		return awserr.New(ecr.ErrCodeRepositoryNotFoundException, "repository not in the simulation", nil)
+	}
+	fn(&ecr.DescribeImagesOutput{ImageDetails: images}, true)
+	return nil
+}
+
+// DescribeImageScanFindingsWithContext reports the imageScanStatus and
+// imageScanFindingsSummary of the image in the fixture.
+func (s *simulatedECR) DescribeImageScanFindingsWithContext(_ aws.Context, in *ecr.DescribeImageScanFindingsInput, _ ...request.Option) (*ecr.DescribeImageScanFindingsOutput, error) {
+	for _, image := range s.images[aws.StringValue(in.RepositoryName)] {
+		if aws.StringValue(image.ImageDigest) != aws.StringValue(in.ImageId.ImageDigest) {
+			continue
+		}
+		if image.ImageScanStatus == nil {
+			break
+		}
+		out := &ecr.DescribeImageScanFindingsOutput{ImageScanStatus: image.ImageScanStatus}
+		if image.ImageScanFindingsSummary != nil {
+			out.ImageScanFindings = &ecr.ImageScanFindings{
+				FindingSeverityCounts: image.ImageScanFindingsSummary.FindingSeverityCounts,
+			}
+		}
+		return out, nil
+	}
+	return nil, awserr.New(ecr.ErrCodeScanNotFoundException, "no scan in the simulation", nil)
+}
+
+// GetLifecyclePolicyWithContext reports that no repository has a
+// lifecycle policy.
+func (s *simulatedECR) GetLifecyclePolicyWithContext(aws.Context, *ecr.GetLifecyclePolicyInput, ...request.Option) (*ecr.GetLifecyclePolicyOutput, error) {
+	return nil, awserr.New(ecr.ErrCodeLifecyclePolicyNotFoundException, "no lifecycle policy in the simulation", nil)
+}
+
+func (s *simulatedECR) ListTagsForResourceWithContext(aws.Context, *ecr.ListTagsForResourceInput, ...request.Option) (*ecr.ListTagsForResourceOutput, error) {
+	return &ecr.ListTagsForResourceOutput{}, nil
+}
+
+func (s *simulatedECR) BatchDeleteImageWithContext(aws.Context, *ecr.BatchDeleteImageInput, ...request.Option) (*ecr.BatchDeleteImageOutput, error) {
+	return nil, errSimulated
+}
+
+func (s *simulatedECR) DeleteRepositoryWithContext(aws.Context, *ecr.DeleteRepositoryInput, ...request.Option) (*ecr.DeleteRepositoryOutput, error) {
+	return nil, errSimulated
+}
+
+// BatchGetImageWithContext returns no images, as the fixture holds no
+// manifests.
+func (s *simulatedECR) BatchGetImageWithContext(aws.Context, *ecr.BatchGetImageInput, ...request.Option) (*ecr.BatchGetImageOutput, error) {
+	return &ecr.BatchGetImageOutput{}, nil
+}
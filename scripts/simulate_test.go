@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFixture writes a -simulate fixture to a temporary file.
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadSimulation(t *testing.T) {
+	sim, err := loadSimulation(writeFixture(t, `{"repositories": [
+		{"repositoryName": "web", "imageDetails": [
+			{"imageDigest": "sha256:aaa", "imageTags": ["latest-3"], "imagePushedAt": "2026-10-10T10:00:00Z"},
+			{"imageDigest": "sha256:bbb", "imagePushedAt": "2026-06-01T10:00:00Z"}
+		]},
+		{"repositoryName": "empty"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sim.names) != 2 || sim.imageCount() != 2 {
+		t.Errorf("loaded %v with %d images, want 2 repositories and 2 images", sim.names, sim.imageCount())
+	}
+}
+
+func TestLoadSimulationRejectsBadFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unknown field", `{"repositories": [{"repositoryName": "web", "images": []}]}`, `unknown field "images"`},
+		{"no name", `{"repositories": [{"imageDetails": []}]}`, "repository without a repositoryName"},
+		{"listed twice", `{"repositories": [{"repositoryName": "web"}, {"repositoryName": "web"}]}`, `"web" is listed twice`},
+		{"no digest", `{"repositories": [{"repositoryName": "api", "imageDetails": [{"imageTags": ["v1"]}]}]}`, `image 1 of repository "api" has no imageDigest`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadSimulation(writeFixture(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadSimulation err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}